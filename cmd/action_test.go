@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/yaml.v3"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+//
+// Test suite definition.
+//
+
+type ActionTestSuite struct {
+	suite.Suite
+}
+
+func TestActionTestSuite(t *testing.T) {
+	suite.Run(t, new(ActionTestSuite))
+}
+
+//
+// Tests.
+//
+
+// actionDefinition is the part of the action definition passing the inputs to the command.
+type actionDefinition struct {
+	Inputs map[string]struct {
+		Default string `yaml:"default"`
+	} `yaml:"inputs"`
+	Runs struct {
+		Args []string `yaml:"args"`
+	} `yaml:"runs"`
+}
+
+// The references to the inputs in the arguments of the action.
+var inputReferenceRegex = regexp.MustCompile(`\$\{\{ inputs\.([a-z-]+) }}`)
+
+func (s *ActionTestSuite) TestActionArguments() {
+	r := s.Require()
+
+	content, err := os.ReadFile("../action.yml")
+	r.NoError(err)
+	var action actionDefinition
+	r.NoError(yaml.Unmarshal(content, &action))
+
+	for _, boolValue := range []string{"false", "true"} {
+		// Build the arguments as the runner does, from the default values of the inputs.
+		var args []string
+		for _, arg := range action.Runs.Args {
+			args = append(args, inputReferenceRegex.ReplaceAllStringFunc(arg, func(reference string) string {
+				input := inputReferenceRegex.FindStringSubmatch(reference)[1]
+				value := action.Inputs[input].Default
+				switch {
+				case value == "false":
+					return boolValue
+				case value == "" || strings.Contains(value, "${{"):
+					return "value"
+				default:
+					return value
+				}
+			}))
+		}
+
+		// The arguments are the flags of the root command, no positional argument is left.
+		cmd, flags, err := rootCmd.Find(args)
+		r.NoError(err, "bool inputs set to %s", boolValue)
+		r.Equal(rootCmd, cmd)
+		r.NoError(cmd.ParseFlags(flags))
+		r.Empty(cmd.Flags().Args())
+		r.Equal(boolValue == "true", dryRun)
+		r.Equal(boolValue == "true", debug)
+	}
+}
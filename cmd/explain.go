@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pcasteran/ghcr-cleaning-action/pkg"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"io"
+	"strings"
+)
+
+var explainCmd = &cobra.Command{
+	Use:   "explain <tag|digest>",
+	Short: "Explain the decision taken for a package version, without deleting anything",
+	Args:  cobra.ExactArgs(1),
+	Run:   doExplain,
+}
+
+// The output format of the explanation.
+var explainOutput string
+
+func init() {
+	addPackageFlags(explainCmd.Flags())
+	addPolicyFlags(explainCmd.Flags())
+	explainCmd.Flags().StringVar(&explainOutput, "output", "text", "the output format: 'text' or 'json'")
+
+	_ = explainCmd.MarkFlagRequired("user")
+	_ = explainCmd.MarkFlagRequired("package")
+	_ = explainCmd.MarkFlagRequired("repository")
+
+	rootCmd.AddCommand(explainCmd)
+}
+
+func doExplain(cmd *cobra.Command, args []string) {
+	setUp()
+
+	if explainOutput != "text" && explainOutput != "json" {
+		log.Fatal().Str("output", explainOutput).Msg("invalid output format, must be either text or json")
+	}
+
+	prFilterParams, commitFilterParams, labelFilterParams := getFilterParams()
+
+	ctx, cancel := newContext()
+	defer cancel()
+
+	// Create the GitHub and container registry clients.
+	ghClient, regClient, err := createClients(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to create the clients")
+	}
+
+	// Explain the decision.
+	explanation, err := pkg.Explain(ctx, ghClient, prFilterParams, commitFilterParams, labelFilterParams, regClient, getPackageRegistryParams(), args[0])
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to explain the decision")
+	}
+
+	if explainOutput == "json" {
+		encoder := json.NewEncoder(cmd.OutOrStdout())
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(explanation)
+	} else {
+		err = writeExplanation(cmd.OutOrStdout(), explanation)
+	}
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to write the explanation")
+	}
+}
+
+// writeExplanation writes an explanation in a human-readable format.
+func writeExplanation(w io.Writer, explanation *pkg.Explanation) error {
+	var b strings.Builder
+	writeField := func(name string, values ...string) {
+		value := strings.Join(values, ", ")
+		if value == "" {
+			value = "-"
+		}
+		fmt.Fprintf(&b, "%-14s %s\n", name+":", value)
+	}
+	writeList := func(name string, lines []string) {
+		if len(lines) == 0 {
+			writeField(name)
+			return
+		}
+		b.WriteString(name + ":\n")
+		for _, line := range lines {
+			b.WriteString("  - " + line + "\n")
+		}
+	}
+
+	writeField("Hash", explanation.Hash)
+	if explanation.VersionID != 0 {
+		writeField("Version ID", fmt.Sprint(explanation.VersionID))
+	}
+	writeField("Tags", explanation.Tags...)
+	writeField("Parents", explanation.Parents...)
+	writeField("Children", explanation.Children...)
+
+	var verdicts []string
+	for _, verdict := range explanation.Verdicts {
+		line := verdict.Policy
+		if verdict.Subject != "" {
+			line += fmt.Sprintf(" '%s'", verdict.Subject)
+		}
+		line += ": " + verdict.Verdict
+		if verdict.Detail != "" {
+			line += fmt.Sprintf(" (%s)", verdict.Detail)
+		}
+		verdicts = append(verdicts, line)
+	}
+	writeList("Verdicts", verdicts)
+
+	var pullRequests []string
+	for _, pr := range explanation.PullRequests {
+		pullRequests = append(pullRequests, fmt.Sprintf("%s/%s#%d: %s", pr.Owner, pr.Repository, pr.Number, pr.State))
+	}
+	writeList("Pull requests", pullRequests)
+
+	decision := explanation.Decision
+	switch {
+	case decision == nil:
+		writeField("Decision", "none, the registry object could not be retrieved")
+	case decision.Delete:
+		writeField("Decision", fmt.Sprintf("delete (%s)", decision.Reason))
+	default:
+		writeField("Decision", fmt.Sprintf("keep (%s)", decision.Reason))
+	}
+	if decision != nil && decision.Provenance != "" {
+		writeField("Provenance", decision.Provenance)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"bytes"
+	"github.com/pcasteran/ghcr-cleaning-action/pkg"
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+//
+// Test suite definition.
+//
+
+type ExplainTestSuite struct {
+	suite.Suite
+}
+
+func TestExplainTestSuite(t *testing.T) {
+	suite.Run(t, new(ExplainTestSuite))
+}
+
+//
+// Tests.
+//
+
+func (s *ExplainTestSuite) TestWriteExplanation() {
+	var out bytes.Buffer
+	err := writeExplanation(&out, &pkg.Explanation{
+		Hash:      "sha256:1",
+		VersionID: 42,
+		Tags:      []string{"pr-2", "pr-3"},
+		Parents:   []string{"sha256:2"},
+		Verdicts: []pkg.Verdict{
+			{Policy: "pull request tag", Subject: "pr-2", Verdict: "obsolete", Detail: "pull request owner/repository#2 is closed"},
+		},
+		PullRequests: []pkg.PullRequestState{
+			{Owner: "owner", Repository: "repository", Number: 2, State: "closed"},
+		},
+		Decision: &pkg.Decision{
+			Hash:       "sha256:1",
+			Delete:     true,
+			Reason:     "all tags obsolete",
+			Provenance: "deleted because its only parent sha256:2 was deleted",
+		},
+	})
+
+	r := s.Require()
+	r.NoError(err)
+	r.Equal(`Hash:          sha256:1
+Version ID:    42
+Tags:          pr-2, pr-3
+Parents:       sha256:2
+Children:      -
+Verdicts:
+  - pull request tag 'pr-2': obsolete (pull request owner/repository#2 is closed)
+Pull requests:
+  - owner/repository#2: closed
+Decision:      delete (all tags obsolete)
+Provenance:    deleted because its only parent sha256:2 was deleted
+`, out.String())
+}
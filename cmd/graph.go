@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pcasteran/ghcr-cleaning-action/pkg"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"strings"
+)
+
+var graphCmd = &cobra.Command{
+	Use:   "graph",
+	Short: "Render the reference graph of a package colored by decision, without deleting anything",
+	Args:  cobra.NoArgs,
+	Run:   doGraph,
+}
+
+// The output format of the graph.
+var graphFormat string
+
+func init() {
+	addPackageFlags(graphCmd.Flags())
+	addPolicyFlags(graphCmd.Flags())
+	graphCmd.Flags().StringVar(&graphFormat, "format", "dot", "the output format: 'dot' or 'mermaid'")
+
+	_ = graphCmd.MarkFlagRequired("user")
+	_ = graphCmd.MarkFlagRequired("package")
+	_ = graphCmd.MarkFlagRequired("repository")
+
+	rootCmd.AddCommand(graphCmd)
+}
+
+func doGraph(cmd *cobra.Command, args []string) {
+	_ = args
+	setUp()
+
+	if graphFormat != "dot" && graphFormat != "mermaid" {
+		log.Fatal().Str("format", graphFormat).Msg("invalid graph format, must be either dot or mermaid")
+	}
+
+	prFilterParams, commitFilterParams, labelFilterParams := getFilterParams()
+
+	ctx, cancel := newContext()
+	defer cancel()
+
+	// Create the GitHub and container registry clients.
+	ghClient, regClient, err := createClients(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to create the clients")
+	}
+
+	// Compute the decisions.
+	plan, err := pkg.Evaluate(ctx, ghClient, prFilterParams, commitFilterParams, labelFilterParams, regClient, getPackageRegistryParams())
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to compute the cleaning plan")
+	}
+
+	if graphFormat == "mermaid" {
+		err = plan.WriteMermaid(cmd.OutOrStdout())
+	} else {
+		err = plan.WriteDOT(cmd.OutOrStdout())
+	}
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to write the graph")
+	}
+}
+
+// appendJobSummary appends the Mermaid graph of a plan to the summary of the GitHub Actions job, whose path is set by
+// the GITHUB_STEP_SUMMARY environment variable.
+func appendJobSummary(plan *pkg.Plan) error {
+	summaryPath := os.Getenv("GITHUB_STEP_SUMMARY")
+	if summaryPath == "" {
+		return errors.New("the GITHUB_STEP_SUMMARY environment variable is not set")
+	}
+
+	file, err := os.OpenFile(summaryPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("unable to open the job summary: %w", err)
+	}
+	defer file.Close()
+
+	if err := writeGraphSummary(file, strings.ToLower(fmt.Sprintf("%s/%s", user, packageName)), plan); err != nil {
+		return fmt.Errorf("unable to write the job summary: %w", err)
+	}
+	return nil
+}
+
+// writeGraphSummary writes the Mermaid graph of a plan in a Markdown section, along with the number of deletions.
+func writeGraphSummary(w io.Writer, name string, plan *pkg.Plan) error {
+	var graph strings.Builder
+	if err := plan.WriteMermaid(&graph); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "### Package %s\n\n%d out of %d package version(s) to delete.\n\n```mermaid\n%s```\n\n",
+		name, len(plan.HashesToDelete()), len(plan.Decisions), graph.String())
+	return err
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"github.com/pcasteran/ghcr-cleaning-action/pkg"
+	"github.com/stretchr/testify/suite"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//
+// Test suite definition.
+//
+
+type GraphTestSuite struct {
+	suite.Suite
+}
+
+func TestGraphTestSuite(t *testing.T) {
+	suite.Run(t, new(GraphTestSuite))
+}
+
+//
+// Tests.
+//
+
+func (s *GraphTestSuite) TestAppendJobSummary() {
+	summaryPath := filepath.Join(s.T().TempDir(), "summary.md")
+	s.T().Setenv("GITHUB_STEP_SUMMARY", summaryPath)
+	user, packageName = "User", "package"
+
+	r := s.Require()
+	r.NoError(os.WriteFile(summaryPath, []byte("# Previous step\n\n"), 0o644))
+	r.NoError(appendJobSummary(&pkg.Plan{Decisions: []*pkg.Decision{
+		{Hash: "sha256:1", Tags: []string{"v1"}, Manifests: []string{"sha256:2"}},
+		{Hash: "sha256:2", Delete: true},
+	}}))
+
+	summary, err := os.ReadFile(summaryPath)
+	r.NoError(err)
+	r.Equal("# Previous step\n\n"+`### Package user/package
+
+1 out of 2 package version(s) to delete.
+
+`+"```mermaid"+`
+flowchart TD
+  n0["sha256:1<br/>v1"]
+  n1["sha256:2"]
+  n0 --> n1
+  classDef keep fill:#d4edda
+  classDef delete fill:#f8d7da
+  class n0 keep
+  class n1 delete
+`+"```\n\n", string(summary))
+}
+
+func (s *GraphTestSuite) TestAppendJobSummaryUnset() {
+	s.T().Setenv("GITHUB_STEP_SUMMARY", "")
+
+	r := s.Require()
+	r.ErrorContains(appendJobSummary(&pkg.Plan{}), "GITHUB_STEP_SUMMARY")
+}
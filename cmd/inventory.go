@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/pcasteran/ghcr-cleaning-action/pkg"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var inventoryCmd = &cobra.Command{
+	Use:     "ls",
+	Aliases: []string{"inventory"},
+	Short:   "List the versions of a package along with their registry object, without deleting anything",
+	Args:    cobra.NoArgs,
+	Run:     doInventory,
+}
+
+// The output format of the inventory.
+var inventoryOutput string
+
+func init() {
+	addPackageFlags(inventoryCmd.Flags())
+	inventoryCmd.Flags().StringVar(&inventoryOutput, "output", "table", "the output format: 'table', 'json' or 'csv'")
+
+	_ = inventoryCmd.MarkFlagRequired("user")
+	_ = inventoryCmd.MarkFlagRequired("package")
+
+	rootCmd.AddCommand(inventoryCmd)
+}
+
+func doInventory(cmd *cobra.Command, args []string) {
+	_ = args
+	setUp()
+
+	if inventoryOutput != "table" && inventoryOutput != "json" && inventoryOutput != "csv" {
+		log.Fatal().Str("output", inventoryOutput).Msg("invalid output format, must be either table, json or csv")
+	}
+
+	ctx, cancel := newContext()
+	defer cancel()
+
+	// Create the GitHub and container registry clients.
+	ghClient, regClient, err := createClients(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to create the clients")
+	}
+
+	// List the package versions.
+	items, err := pkg.Inventory(ctx, ghClient, regClient, getPackageRegistryParams())
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to list the package versions")
+	}
+
+	switch inventoryOutput {
+	case "json":
+		encoder := json.NewEncoder(cmd.OutOrStdout())
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(items)
+	case "csv":
+		err = writeInventoryCSV(cmd.OutOrStdout(), items)
+	default:
+		err = writeInventoryTable(cmd.OutOrStdout(), items)
+	}
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to write the inventory")
+	}
+}
+
+// writeInventoryTable writes an inventory as a table, the digests being shortened and the sizes human-readable.
+func writeInventoryTable(w io.Writer, items []*pkg.InventoryItem) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DIGEST\tVERSION ID\tTAGS\tCREATED\tUPDATED\tKIND\tPLATFORMS\tSIZE\tPARENTS")
+	for _, item := range items {
+		var parents []string
+		for _, parent := range item.Parents {
+			parents = append(parents, pkg.ShortDigest(parent))
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			pkg.ShortDigest(item.Digest),
+			orDash(formatVersionID(item.VersionID)),
+			orDash(strings.Join(item.Tags, ",")),
+			orDash(formatTime(item.CreatedAt)),
+			orDash(formatTime(item.UpdatedAt)),
+			item.Kind,
+			orDash(strings.Join(item.Platforms, ",")),
+			formatSize(item.Size),
+			orDash(strings.Join(parents, ",")),
+		)
+	}
+	return tw.Flush()
+}
+
+// writeInventoryCSV writes an inventory as CSV, the list fields being space separated.
+func writeInventoryCSV(w io.Writer, items []*pkg.InventoryItem) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"digest", "version_id", "tags", "created_at", "updated_at", "kind", "platforms", "size", "parents"})
+	for _, item := range items {
+		_ = cw.Write([]string{
+			item.Digest,
+			formatVersionID(item.VersionID),
+			strings.Join(item.Tags, " "),
+			formatTime(item.CreatedAt),
+			formatTime(item.UpdatedAt),
+			item.Kind,
+			strings.Join(item.Platforms, " "),
+			fmt.Sprint(item.Size),
+			strings.Join(item.Parents, " "),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// formatVersionID returns a package version id, or an empty string when unknown, e.g. with the registry backend.
+func formatVersionID(id int64) string {
+	if id == 0 {
+		return ""
+	}
+	return fmt.Sprint(id)
+}
+
+// formatTime returns a time in the RFC 3339 format, or an empty string when unknown.
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// formatSize returns a size in bytes in a human-readable format, e.g. 1.5 MB.
+func formatSize(size int64) string {
+	const unit = 1000
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value, exponent := float64(size)/unit, 0
+	for value >= unit && exponent < 3 {
+		value /= unit
+		exponent++
+	}
+	return fmt.Sprintf("%.1f %cB", value, "kMGT"[exponent])
+}
+
+// orDash returns a value, or a dash if it is empty.
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"bytes"
+	"github.com/pcasteran/ghcr-cleaning-action/pkg"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+//
+// Test suite definition.
+//
+
+type InventoryTestSuite struct {
+	suite.Suite
+}
+
+func TestInventoryTestSuite(t *testing.T) {
+	suite.Run(t, new(InventoryTestSuite))
+}
+
+//
+// Tests.
+//
+
+var inventoryItems = func() []*pkg.InventoryItem {
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	return []*pkg.InventoryItem{
+		{
+			Digest:    "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+			VersionID: 42,
+			Tags:      []string{"v1", "latest"},
+			CreatedAt: &createdAt,
+			UpdatedAt: &createdAt,
+			Kind:      pkg.IndexKind,
+			Platforms: []string{"linux/amd64", "linux/arm64/v8"},
+			Size:      512,
+		},
+		{
+			Digest:    "sha256:2222222222222222222222222222222222222222222222222222222222222222",
+			Kind:      pkg.ImageKind,
+			Platforms: []string{"linux/amd64"},
+			Size:      1_500_000,
+			Parents:   []string{"sha256:1111111111111111111111111111111111111111111111111111111111111111"},
+		},
+	}
+}()
+
+func (s *InventoryTestSuite) TestWriteInventoryTable() {
+	var out bytes.Buffer
+	r := s.Require()
+	r.NoError(writeInventoryTable(&out, inventoryItems))
+	r.Equal(`DIGEST               VERSION ID  TAGS       CREATED               UPDATED               KIND   PLATFORMS                   SIZE    PARENTS
+sha256:111111111111  42          v1,latest  2024-01-02T03:04:05Z  2024-01-02T03:04:05Z  index  linux/amd64,linux/arm64/v8  512 B   -
+sha256:222222222222  -           -          -                     -                     image  linux/amd64                 1.5 MB  sha256:111111111111
+`, out.String())
+}
+
+func (s *InventoryTestSuite) TestWriteInventoryCSV() {
+	var out bytes.Buffer
+	r := s.Require()
+	r.NoError(writeInventoryCSV(&out, inventoryItems))
+	r.Equal(`digest,version_id,tags,created_at,updated_at,kind,platforms,size,parents
+sha256:1111111111111111111111111111111111111111111111111111111111111111,42,v1 latest,2024-01-02T03:04:05Z,2024-01-02T03:04:05Z,index,linux/amd64 linux/arm64/v8,512,
+sha256:2222222222222222222222222222222222222222222222222222222222222222,,,,,image,linux/amd64,1500000,sha256:1111111111111111111111111111111111111111111111111111111111111111
+`, out.String())
+}
+
+func (s *InventoryTestSuite) TestFormatSize() {
+	r := s.Require()
+	r.Equal("0 B", formatSize(0))
+	r.Equal("999 B", formatSize(999))
+	r.Equal("1.0 kB", formatSize(1000))
+	r.Equal("2.5 GB", formatSize(2_500_000_000))
+}
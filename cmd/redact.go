@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// The patterns of the GitHub tokens: personal access tokens (classic and fine-grained), OAuth, user-to-server,
+// server-to-server (e.g. GitHub App installation tokens) and refresh tokens.
+var githubTokenRegex = regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)
+
+const redacted = "[REDACTED]"
+
+// redactingWriter is a writer masking the secrets, either known ones or looking like GitHub tokens, before writing
+// to the underlying writer.
+type redactingWriter struct {
+	out     io.Writer
+	mutex   sync.RWMutex
+	secrets []string
+}
+
+func newRedactingWriter(out io.Writer, secrets ...string) *redactingWriter {
+	w := &redactingWriter{out: out}
+	w.addSecrets(secrets...)
+	return w
+}
+
+// addSecrets adds secrets to mask, e.g. the ones only known once the credentials are resolved.
+func (w *redactingWriter) addSecrets(secrets ...string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for _, secret := range secrets {
+		// Also mask each line of the multi-line secrets (e.g. private keys), as the log writer escapes the new lines.
+		for _, line := range append(strings.Split(secret, "\n"), secret) {
+			line = strings.TrimSpace(line)
+			if line != "" {
+				w.secrets = append(w.secrets, line)
+			}
+		}
+	}
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	s := string(p)
+	w.mutex.RLock()
+	for _, secret := range w.secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	w.mutex.RUnlock()
+	s = githubTokenRegex.ReplaceAllString(s, redacted)
+
+	// Report the length of the original data, as expected by the callers.
+	_, err := w.out.Write([]byte(s))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// The writer of the logs, masking the secrets.
+var logWriter *redactingWriter
+
+// redactSecrets makes sure secrets resolved after the configuration of the logging never appear in the logs.
+func redactSecrets(secrets ...string) {
+	if logWriter != nil {
+		logWriter.addSecrets(secrets...)
+	}
+}
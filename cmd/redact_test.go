@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+//
+// Test suite definition.
+//
+
+type RedactTestSuite struct {
+	suite.Suite
+}
+
+func TestRedactTestSuite(t *testing.T) {
+	suite.Run(t, new(RedactTestSuite))
+}
+
+//
+// Tests.
+//
+
+func (s *RedactTestSuite) TestRedactingWriter() {
+	var out bytes.Buffer
+	w := newRedactingWriter(&out, "my-secret-password", "")
+
+	token := "ghp_" + "0123456789abcdefghijklmnopqrstuvwxyzAB"
+	input := "password=my-secret-password token=" + token + " user=me"
+	n, err := w.Write([]byte(input))
+
+	r := s.Require()
+	r.NoError(err)
+	r.Equal(len(input), n)
+	r.Equal("password=[REDACTED] token=[REDACTED] user=me", out.String())
+}
+
+func (s *RedactTestSuite) TestRedactingWriterAddSecrets() {
+	var out bytes.Buffer
+	w := newRedactingWriter(&out)
+	w.addSecrets("-----BEGIN KEY-----\nc2VjcmV0\n-----END KEY-----")
+
+	_, err := w.Write([]byte("key=c2VjcmV0"))
+
+	r := s.Require()
+	r.NoError(err)
+	r.Equal("key=[REDACTED]", out.String())
+}
@@ -2,49 +2,122 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/google/go-github/v49/github"
 	"github.com/pcasteran/ghcr-cleaning-action/pkg"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/oauth2"
 	"os"
+	"os/signal"
 	"regexp"
 	"strings"
+	"syscall"
+	"time"
 )
 
 var rootCmd = &cobra.Command{
-	Use:   "ghcr-cleaning-action",
-	Short: "GitHub action allowing to clean a GitHub Container registry",
-	Run:   doExecute,
+	Use:               "ghcr-cleaning-action",
+	Short:             "GitHub action allowing to clean a GitHub Container registry",
+	PersistentPreRunE: bindEnvironmentVariables,
+	Run:               doExecute,
 }
 
+// The prefix of the environment variables from which the flags values are read.
+const envPrefix = "GHCR_CLEANING_"
+
 var (
-	debug        bool
-	dryRun       bool
-	registry     string
-	user         string
-	password     string
-	packageName  string
-	repository   string
-	prTagPattern string
+	debug            bool
+	dryRun           bool
+	registry         string
+	githubAPIURL     string
+	githubUploadURL  string
+	backend          string
+	deletionStrategy string
+	user             string
+	password         string
+	passwordFile     string
+
+	appID             int64
+	appPrivateKey     string
+	appPrivateKeyFile string
+	appInstallationID int64
+
+	packagesToken     string
+	repositoriesToken string
+	registryUser      string
+	registryPassword  string
+
+	packageName   string
+	repository    string
+	prTagPatterns []string
+	prRepos       []string
+
+	commitTagPattern       string
+	protectedBranchPattern string
+	protectedTagPattern    string
+
+	labelLookup bool
+
+	planFile        string
+	graphSummary    bool
+	prunedPlatforms []string
+
+	timeout         time.Duration
+	registryTimeout time.Duration
 )
 
 func init() {
-	rootCmd.Flags().BoolVar(&debug, "debug", false, "enable the debug logs")
+	addPackageFlags(rootCmd.Flags())
+	addPolicyFlags(rootCmd.Flags())
+	rootCmd.Flags().StringVar(&deletionStrategy, "deletion-strategy", "", "the strategy to delete the package versions: 'github' for the GitHub Packages API, 'registry' for a registry manifest DELETE request, or 'both' for the GitHub Packages API with a fallback on the registry; defaults to the one of the backend")
 	rootCmd.Flags().BoolVar(&dryRun, "dry-run", false, "if true, compute everything but do no perform the deletion")
-	rootCmd.Flags().StringVar(&registry, "registry", "ghcr.io", "the URL of the container registry")
-	rootCmd.Flags().StringVar(&user, "user", "", "the container registry user")
-	rootCmd.Flags().StringVar(&password, "password", "", "the container registry user password or access token")
-	rootCmd.Flags().StringVar(&packageName, "package", "", "the name of the package to clean")
-	rootCmd.Flags().StringVar(&repository, "repository", "", "the GitHub repository (format owner/repository) in which to check the pull requests statuses")
-	rootCmd.Flags().StringVar(&prTagPattern, "pr-tag-regex", pkg.DefaultPrTagPattern, "the regular expression used to match the pull request tags, must include one capture group for the PR id")
+	rootCmd.Flags().StringSliceVar(&prunedPlatforms, "prune-platforms", nil, "the platforms (format os/architecture[/variant]) to remove from the kept image indices, which are pushed again under the same tags; the manifests of these platforms are then deleted")
+	rootCmd.Flags().StringVar(&planFile, "plan-file", "", "if set, the path of the file in which the cleaning plan is written in JSON format")
+	rootCmd.Flags().BoolVar(&graphSummary, "graph-summary", false, "if true, append the reference graph of the package in Mermaid format to the GitHub Actions job summary")
 
 	_ = rootCmd.MarkFlagRequired("user")
-	_ = rootCmd.MarkFlagRequired("password")
 	_ = rootCmd.MarkFlagRequired("package")
 	_ = rootCmd.MarkFlagRequired("repository")
 }
 
+// addPackageFlags adds the flags used to access a package: the GitHub and container registry settings and credentials.
+func addPackageFlags(flags *pflag.FlagSet) {
+	flags.BoolVar(&debug, "debug", false, "enable the debug logs")
+	flags.DurationVar(&timeout, "timeout", 0, "the maximum duration of the whole command, e.g. 30m; if 0, there is no limit")
+	flags.StringVar(&registry, "registry", "", "the URL of the container registry; defaults to ghcr.io, or to the container registry of the GitHub Enterprise Server")
+	flags.StringVar(&githubAPIURL, "github-api-url", "", "the URL of the GitHub API, e.g. https://HOSTNAME/api/v3 for a GitHub Enterprise Server; defaults to the GITHUB_API_URL environment variable or to the public GitHub API")
+	flags.StringVar(&githubUploadURL, "github-upload-url", "", "the upload URL of the GitHub Enterprise Server API; defaults to https://HOSTNAME/api/uploads")
+	flags.StringVar(&backend, "backend", pkg.GithubBackend, "the backend listing the package versions: 'github' for the GitHub Packages API (GitHub Container registry only) or 'registry' for the OCI distribution API (any registry)")
+	flags.DurationVar(&registryTimeout, "registry-timeout", pkg.DefaultRegistryRequestTimeout, "the timeout of each request to the container registry, the requests timing out are retried")
+	flags.StringVar(&user, "user", "", "the container registry user")
+	flags.StringVar(&password, "password", "", "the container registry user password or access token, prefer the GHCR_CLEANING_PASSWORD environment variable or the password file; if not set, the GITHUB_TOKEN environment variable or the credentials stored in the Docker configuration for the registry are used")
+	flags.StringVar(&passwordFile, "password-file", "", "the path of the file containing the container registry user password or access token")
+	flags.Int64Var(&appID, "app-id", 0, "the identifier of the GitHub App to authenticate as, instead of using a password")
+	flags.StringVar(&appPrivateKey, "app-private-key", "", "the PEM encoded private key of the GitHub App")
+	flags.StringVar(&appPrivateKeyFile, "app-private-key-file", "", "the path of the file containing the PEM encoded private key of the GitHub App")
+	flags.Int64Var(&appInstallationID, "app-installation-id", 0, "the identifier of the GitHub App installation; if not set, the installation for the user is looked up")
+	flags.StringVar(&packagesToken, "packages-token", "", "the access token used for the GitHub packages API (listing and deletion of the package versions); defaults to the password or the GitHub App token")
+	flags.StringVar(&repositoriesToken, "repositories-token", "", "the access token used for the GitHub repositories API (pull requests, branches, tags and commits); defaults to the password or the GitHub App token")
+	flags.StringVar(&registryUser, "registry-user", "", "the container registry user used for the registry authentication; defaults to the user")
+	flags.StringVar(&registryPassword, "registry-password", "", "the container registry password or access token; defaults to the password or the GitHub App token")
+	flags.StringVar(&packageName, "package", "", "the name of the package to clean")
+}
+
+// addPolicyFlags adds the flags of the policies deciding which package versions are kept.
+func addPolicyFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&repository, "repository", "", "the GitHub repository (format owner/repository) in which to check the pull requests statuses")
+	flags.StringArrayVar(&prTagPatterns, "pr-tag-regex", []string{pkg.DefaultPrTagPattern}, "the regular expression used to match the pull request tags, must include either an 'id' named capture group or one capture group for the PR id; can be repeated or contain several newline separated expressions")
+	flags.StringSliceVar(&prRepos, "pr-repositories", nil, "the repositories (format name=owner/repository) in which to check the pull requests statuses, by value of the 'repo' capture group of the pull request tag regex")
+	flags.StringVar(&commitTagPattern, "commit-tag-regex", "", "the regular expression used to match the commit tags, must include one capture group for the commit SHA; if empty, the commit tags are not checked")
+	flags.StringVar(&protectedBranchPattern, "protected-branch-regex", pkg.DefaultProtectedBranchPattern, "the regular expression used to match the branches from which a commit tag must be reachable to be kept")
+	flags.StringVar(&protectedTagPattern, "protected-tag-regex", "", "the regular expression used to match the Git tags from which a commit tag must be reachable to be kept")
+	flags.BoolVar(&labelLookup, "label-lookup", false, "if true, use the revision label of the images to look up their commit and pull requests")
+}
+
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
@@ -57,43 +130,461 @@ func doExecute(cmd *cobra.Command, args []string) {
 	_ = cmd
 	_ = args
 
+	setUp()
+
+	switch deletionStrategy {
+	case "", pkg.GithubDeletion, pkg.RegistryDeletion, pkg.BothDeletion:
+	default:
+		log.Fatal().Str("deletion-strategy", deletionStrategy).Msg("invalid deletion strategy, must be either github, registry or both")
+	}
+
+	prFilterParams, commitFilterParams, labelFilterParams := getFilterParams()
+
+	// Stop the cleaning on interruption (e.g. Ctrl-C or job cancellation) or when its deadline is exceeded.
+	ctx, cancel := newContext()
+	defer cancel()
+
+	// Create the GitHub and container registry clients.
+	ghClient, regClient, err := createClients(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to create the clients")
+	}
+
+	// Perform the registry cleaning.
+	pkgRegistryParams := getPackageRegistryParams()
+	plan, err := pkg.Clean(ctx, ghClient, prFilterParams, commitFilterParams, labelFilterParams, regClient, pkgRegistryParams, dryRun)
+
+	// Log the remaining API budget, unless the run has been interrupted.
+	if ctx.Err() == nil {
+		logRateLimits(ctx, ghClient)
+	}
+
+	// Write the plan, even if the cleaning failed after it has been computed.
+	if planFile != "" && plan != nil {
+		if err := plan.WriteFile(planFile); err != nil {
+			log.Error().Err(err).Msg("unable to write the cleaning plan")
+		}
+	}
+	if graphSummary && plan != nil {
+		if err := appendJobSummary(plan); err != nil {
+			log.Error().Err(err).Msg("unable to write the reference graph to the job summary")
+		}
+	}
+
+	// Tell what has been done before the interruption.
+	if ctx.Err() != nil {
+		if plan == nil {
+			log.Warn().Msg("registry cleaning interrupted before any deletion")
+		} else if !dryRun {
+			deleted, notDeleted := plan.DeletionStatus()
+			log.Warn().Strs("deleted", deleted).Strs("not-deleted", notDeleted).Msg("registry cleaning interrupted")
+		}
+	}
+
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to perform the registry cleaning")
+	}
+}
+
+// setUp configures the logging, reads the secrets and checks the parameters common to all the commands.
+func setUp() {
 	// Configure the logging.
 	zerolog.SetGlobalLevel(zerolog.InfoLevel)
 	if debug {
 		zerolog.SetGlobalLevel(zerolog.TraceLevel)
 	}
-	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 
-	// Create the GitHub client.
-	ghClient, err := pkg.NewGithubClient(context.Background(), password)
-	if err != nil {
-		log.Fatal().Err(err).Msg("unable to create the GitHub client")
+	// Read the secrets from the files and environment variables if needed.
+	if err := readSecrets(); err != nil {
+		log.Fatal().Err(err).Msg("unable to read the secrets")
 	}
 
-	// Create the container registry client.
-	regClient, err := pkg.NewContainerRegistryClient(user, password)
-	if err != nil {
-		log.Fatal().Err(err).Msg("unable to create the container registry client")
+	// Make sure the secrets never appear in the logs.
+	logWriter = newRedactingWriter(os.Stderr, password, appPrivateKey, packagesToken, repositoriesToken, registryPassword)
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: logWriter})
+
+	// Check the parameters.
+	if backend != pkg.GithubBackend && backend != pkg.RegistryBackend {
+		log.Fatal().Str("backend", backend).Msg("invalid backend, must be either github or registry")
 	}
 
-	// Perform the registry cleaning.
+	// Detect the GitHub Enterprise Server from the environment variable set by the GitHub Actions runner if needed, and
+	// use its container registry by default.
+	if githubAPIURL == "" {
+		githubAPIURL = os.Getenv("GITHUB_API_URL")
+	}
+	if registry == "" {
+		var err error
+		registry, err = pkg.GetContainerRegistryHost(githubAPIURL)
+		if err != nil {
+			log.Fatal().Err(err).Msg("unable to get the container registry of the GitHub instance")
+		}
+	}
+	if !pkg.IsPublicGithubAPIURL(githubAPIURL) {
+		log.Info().Str("api-url", githubAPIURL).Str("registry", registry).Msg("using a GitHub Enterprise Server")
+	}
+}
+
+// getFilterParams checks the parameters of the policies and returns them.
+func getFilterParams() (pkg.PullRequestFilterParams, pkg.CommitFilterParams, pkg.LabelFilterParams) {
 	ownerAndRepo := strings.Split(repository, "/")
 	if len(ownerAndRepo) != 2 {
-		log.Fatal().Err(err).Msg("invalid repository format, must be owner/repository")
+		log.Fatal().Str("repository", repository).Msg("invalid repository format, must be owner/repository")
 	}
 
-	pkgRegistryParams := pkg.PackageRegistryParams{
-		Registry:    registry,
-		User:        user,
-		PackageName: packageName,
+	repositoryByName, err := parseRepositoryMapping(prRepos)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid pull request repositories")
+	}
+
+	// Compile and validate the regular expressions.
+	var prTagRegexes []*regexp.Regexp
+	for _, patterns := range prTagPatterns {
+		for _, pattern := range strings.Split(patterns, "\n") {
+			pattern = strings.TrimSpace(pattern)
+			if pattern == "" {
+				continue
+			}
+
+			regex, err := pkg.NewPrTagRegex(pattern)
+			if err != nil {
+				log.Fatal().Err(err).Msg("invalid pull request tag regex")
+			}
+			prTagRegexes = append(prTagRegexes, regex)
+		}
 	}
+
+	var commitTagRegex *regexp.Regexp
+	if commitTagPattern != "" {
+		commitTagRegex, err = pkg.NewCommitTagRegex(commitTagPattern)
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid commit tag regex")
+		}
+	}
+
+	protectedBranchRegex, err := compileOptionalRegex(protectedBranchPattern)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid protected branch regex")
+	}
+
+	protectedTagRegex, err := compileOptionalRegex(protectedTagPattern)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid protected tag regex")
+	}
+
 	prFilterParams := pkg.PullRequestFilterParams{
-		Owner:      ownerAndRepo[0],
-		Repository: ownerAndRepo[1],
-		TagRegex:   regexp.MustCompile(prTagPattern),
+		Owner:            ownerAndRepo[0],
+		Repository:       ownerAndRepo[1],
+		TagRegexes:       prTagRegexes,
+		RepositoryByName: repositoryByName,
+	}
+	commitFilterParams := pkg.CommitFilterParams{
+		Owner:                ownerAndRepo[0],
+		Repository:           ownerAndRepo[1],
+		TagRegex:             commitTagRegex,
+		ProtectedBranchRegex: protectedBranchRegex,
+		ProtectedTagRegex:    protectedTagRegex,
+	}
+	labelFilterParams := pkg.LabelFilterParams{
+		Enabled: labelLookup,
+	}
+	return prFilterParams, commitFilterParams, labelFilterParams
+}
+
+// getPackageRegistryParams returns the parameters of the package.
+func getPackageRegistryParams() pkg.PackageRegistryParams {
+	return pkg.PackageRegistryParams{
+		Registry:         registry,
+		User:             user,
+		PackageName:      packageName,
+		Backend:          backend,
+		DeletionStrategy: deletionStrategy,
+		PrunedPlatforms:  prunedPlatforms,
+	}
+}
+
+// newContext returns the context of a command, cancelled on interruption (e.g. Ctrl-C or job cancellation) or when
+// the timeout is exceeded.
+func newContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		// Restore the default behavior once interrupted, so that a second signal kills the process right away.
+		<-ctx.Done()
+		stop()
+	}()
+	if timeout <= 0 {
+		return ctx, stop
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+// logRateLimits logs the remaining GitHub API budget of the tokens.
+func logRateLimits(ctx context.Context, ghClient pkg.GithubClient) {
+	rateLimits, err := ghClient.GetRateLimits(ctx)
+	if err != nil {
+		log.Warn().Err(err).Msg("unable to retrieve the GitHub API rate limits")
+		return
+	}
+
+	for _, rate := range []struct {
+		usage string
+		rate  *github.Rate
+	}{{"packages", rateLimits.Packages}, {"repositories", rateLimits.Repositories}} {
+		if rate.rate == nil {
+			// The rate limit may be missing, e.g. when it is disabled on GitHub Enterprise Server.
+			log.Debug().Str("token", rate.usage).Msg("no GitHub API rate limit")
+			continue
+		}
+
+		log.Info().
+			Str("token", rate.usage).
+			Int("remaining", rate.rate.Remaining).
+			Int("limit", rate.rate.Limit).
+			Time("reset", rate.rate.Reset.Time).
+			Msg("GitHub API rate limit")
+	}
+}
+
+// bindEnvironmentVariables sets the value of the flags not set on the command line from the environment variables
+// named after them, e.g. GHCR_CLEANING_DRY_RUN for the `dry-run` flag.
+func bindEnvironmentVariables(cmd *cobra.Command, args []string) error {
+	_ = args
+
+	var err error
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		if err != nil || flag.Changed {
+			return
+		}
+
+		envName := envPrefix + strings.ToUpper(strings.ReplaceAll(flag.Name, "-", "_"))
+		if value, found := os.LookupEnv(envName); found {
+			if setErr := cmd.Flags().Set(flag.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid value for environment variable %s: %w", envName, setErr)
+			}
+		}
+	})
+	return err
+}
+
+// readSecrets reads the password from its file if set, or falls back on the GITHUB_TOKEN environment variable.
+func readSecrets() error {
+	if password == "" && passwordFile != "" {
+		data, err := os.ReadFile(passwordFile)
+		if err != nil {
+			return fmt.Errorf("unable to read the password file: %w", err)
+		}
+		password = strings.TrimSpace(string(data))
+	}
+
+	if password == "" && appID == 0 {
+		password = os.Getenv("GITHUB_TOKEN")
+	}
+
+	return nil
+}
+
+// clientTokenSources are the token sources authenticating the clients. The registry client authenticates with the
+// credentials stored in the Docker configuration if useKeychain is set.
+type clientTokenSources struct {
+	packages     oauth2.TokenSource
+	repositories oauth2.TokenSource
+	registry     oauth2.TokenSource
+	useKeychain  bool
+}
+
+// createClients creates the GitHub and container registry clients.
+func createClients(ctx context.Context) (pkg.GithubClient, pkg.ContainerRegistryClient, error) {
+	tokenSources, err := getClientTokenSources(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Create the clients.
+	var ghOptions []pkg.GithubClientOption
+	if enterpriseAPIURL := getEnterpriseAPIURL(); enterpriseAPIURL != "" {
+		ghOptions = append(ghOptions, pkg.WithGithubEnterpriseURLs(enterpriseAPIURL, githubUploadURL))
 	}
-	err = pkg.Clean(ghClient, prFilterParams, regClient, pkgRegistryParams, dryRun)
+	ghClient, err := pkg.NewGithubClientFromTokenSources(ctx, tokenSources.packages, tokenSources.repositories, ghOptions...)
 	if err != nil {
-		log.Fatal().Err(err).Msg("unable to perform the registry cleaning")
+		return nil, nil, fmt.Errorf("unable to create the GitHub client: %w", err)
+	}
+
+	var regClient pkg.ContainerRegistryClient
+	if tokenSources.useKeychain && registryPassword == "" {
+		// Let the keychain provide the user along with the password, and refresh them if needed.
+		regClient, err = pkg.NewContainerRegistryClientFromKeychain(registryTimeout)
+	} else {
+		if registryUser == "" {
+			registryUser = user
+		}
+		regClient, err = pkg.NewContainerRegistryClientFromTokenSource(registryUser, tokenSources.registry, registryTimeout)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create the container registry client: %w", err)
+	}
+
+	return ghClient, regClient, nil
+}
+
+// getClientTokenSources returns the token sources of the clients. Each client is authenticated with its dedicated
+// credentials if set. Otherwise, when the registry is the one of the GitHub instance, the clients share the password,
+// the GitHub App token or the credentials stored in the Docker configuration for the registry. When it is not, the
+// credentials never cross hosts: the GitHub APIs require their dedicated tokens, and the registry requires its password
+// or the credentials of the Docker configuration.
+func getClientTokenSources(ctx context.Context) (clientTokenSources, error) {
+	var tokenSources clientTokenSources
+	if appID != 0 && password != "" {
+		return tokenSources, errors.New("a password and a GitHub App cannot be both set")
+	}
+
+	// Get the credentials stored in the Docker configuration for the registry, e.g. after a `docker login`, only used
+	// if no other credentials are set.
+	getKeychainTokenSource := func() (oauth2.TokenSource, error) {
+		keychainPassword, err := pkg.GetKeychainPassword(registry)
+		if err != nil || keychainPassword == "" {
+			return nil, err
+		}
+		redactSecrets(keychainPassword)
+
+		log.Debug().Str("registry", registry).Msg("using the credentials of the Docker configuration")
+		tokenSources.useKeychain = true
+		return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: keychainPassword}), nil
+	}
+
+	var err error
+	if !isGithubRegistry() {
+		// The registry is not hosted by GitHub, its credentials must not be sent to GitHub and the GitHub ones to it.
+		if repositoriesToken == "" {
+			return tokenSources, fmt.Errorf("the repositories token must be set, as the registry '%s' is not hosted by GitHub", registry)
+		}
+		tokenSources.repositories = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: repositoriesToken})
+		tokenSources.packages = tokenSources.repositories
+		if backend != pkg.RegistryBackend || packagesToken != "" {
+			if packagesToken == "" {
+				return tokenSources, fmt.Errorf("the packages token must be set, as the registry '%s' is not hosted by GitHub", registry)
+			}
+			tokenSources.packages = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: packagesToken})
+		}
+
+		if registryPassword != "" {
+			tokenSources.registry = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: registryPassword})
+		} else {
+			tokenSources.registry, err = getKeychainTokenSource()
+			if err != nil {
+				return tokenSources, err
+			}
+			if tokenSources.registry == nil {
+				return tokenSources, fmt.Errorf("the registry password must be set, as the registry '%s' is not hosted by GitHub", registry)
+			}
+		}
+		return tokenSources, nil
+	}
+
+	// The registry is hosted by GitHub, all the clients can share the same credentials.
+	var defaultTokenSource oauth2.TokenSource
+	if appID != 0 {
+		// Authenticate as a GitHub App.
+		privateKey := []byte(appPrivateKey)
+		if appPrivateKeyFile != "" {
+			privateKey, err = os.ReadFile(appPrivateKeyFile)
+			if err != nil {
+				return tokenSources, fmt.Errorf("unable to read the GitHub App private key file: %w", err)
+			}
+			redactSecrets(string(privateKey))
+		}
+
+		defaultTokenSource, err = pkg.NewGithubAppTokenSource(ctx, pkg.GithubAppParams{
+			AppID:          appID,
+			PrivateKey:     privateKey,
+			InstallationID: appInstallationID,
+			Owner:          user,
+
+			EnterpriseAPIURL: getEnterpriseAPIURL(),
+		})
+		if err != nil {
+			return tokenSources, fmt.Errorf("unable to authenticate as a GitHub App: %w", err)
+		}
+	} else if password != "" {
+		// Authenticate with the password.
+		defaultTokenSource = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: password})
+	} else {
+		// Fall back on the credentials stored in the Docker configuration for the registry.
+		defaultTokenSource, err = getKeychainTokenSource()
+		if err != nil {
+			return tokenSources, err
+		}
+	}
+
+	tokenSources.repositories, err = getTokenSource(repositoriesToken, defaultTokenSource, "GitHub repositories API")
+	if err != nil {
+		return tokenSources, err
+	}
+	tokenSources.packages = tokenSources.repositories
+	if backend != pkg.RegistryBackend || packagesToken != "" {
+		// The GitHub packages API is only used by the GitHub backend.
+		tokenSources.packages, err = getTokenSource(packagesToken, defaultTokenSource, "GitHub packages API")
+		if err != nil {
+			return tokenSources, err
+		}
+	}
+	tokenSources.registry, err = getTokenSource(registryPassword, defaultTokenSource, "container registry")
+	return tokenSources, err
+}
+
+// isGithubRegistry returns whether the registry is the container registry of the GitHub instance.
+func isGithubRegistry() bool {
+	githubRegistry, err := pkg.GetContainerRegistryHost(githubAPIURL)
+	if err != nil {
+		return false
+	}
+
+	host := strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(registry), "https://"), "http://")
+	return strings.TrimSuffix(host, "/") == githubRegistry
+}
+
+// getEnterpriseAPIURL returns the URL of the API of the GitHub Enterprise Server, or an empty string for the public
+// GitHub.
+func getEnterpriseAPIURL() string {
+	if pkg.IsPublicGithubAPIURL(githubAPIURL) {
+		return ""
+	}
+	return githubAPIURL
+}
+
+// getTokenSource returns a static token source for a token if set, or the default token source otherwise.
+func getTokenSource(token string, defaultTokenSource oauth2.TokenSource, usage string) (oauth2.TokenSource, error) {
+	if token != "" {
+		return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}), nil
+	}
+
+	if defaultTokenSource == nil {
+		return nil, fmt.Errorf("no credentials for the %s, either a dedicated token, a password, a GitHub App or a Docker login must be set", usage)
+	}
+	return defaultTokenSource, nil
+}
+
+// compileOptionalRegex compiles a regular expression, an empty pattern returns a nil regular expression.
+func compileOptionalRegex(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile(pattern)
+}
+
+// parseRepositoryMapping parses a list of repository mappings in the format name=owner/repository.
+func parseRepositoryMapping(mappings []string) (map[string]string, error) {
+	repositoryByName := make(map[string]string)
+	for _, mapping := range mappings {
+		nameAndRepo := strings.SplitN(mapping, "=", 2)
+		if len(nameAndRepo) != 2 || len(strings.Split(nameAndRepo[1], "/")) != 2 {
+			return nil, fmt.Errorf("invalid repository mapping '%s', must be name=owner/repository", mapping)
+		}
+		repositoryByName[nameAndRepo[0]] = nameAndRepo[1]
 	}
+	return repositoryByName, nil
 }
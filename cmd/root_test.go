@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"github.com/google/go-github/v49/github"
+	"github.com/pcasteran/ghcr-cleaning-action/pkg"
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/oauth2"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//
+// Test suite definition.
+//
+
+type ClientsTestSuite struct {
+	suite.Suite
+}
+
+func TestClientsTestSuite(t *testing.T) {
+	suite.Run(t, new(ClientsTestSuite))
+}
+
+type RateLimitsTestSuite struct {
+	suite.Suite
+}
+
+func TestRateLimitsTestSuite(t *testing.T) {
+	suite.Run(t, new(RateLimitsTestSuite))
+}
+
+func (s *ClientsTestSuite) SetupTest() {
+	// Store the credentials of the registries in a Docker configuration.
+	dir := s.T().TempDir()
+	config := `{"auths": {` +
+		`"ghcr.io": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("user:ghcr-keychain-password")) + `"}, ` +
+		`"registry.example.com": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("user:example-keychain-password")) + `"}` +
+		`}}`
+	s.Require().NoError(os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600))
+	s.T().Setenv("DOCKER_CONFIG", dir)
+
+	registry = pkg.DefaultRegistry
+	githubAPIURL = ""
+	backend = pkg.GithubBackend
+	password = ""
+	appID = 0
+	packagesToken = ""
+	repositoriesToken = ""
+	registryPassword = ""
+}
+
+//
+// Tests.
+//
+
+func (s *ClientsTestSuite) TestGithubRegistryPassword() {
+	password = "password"
+
+	// The clients share the password.
+	s.checkTokens("password", "password", "password", false)
+}
+
+func (s *ClientsTestSuite) TestGithubRegistryDedicatedTokens() {
+	password = "password"
+	packagesToken = "packages-token"
+	repositoriesToken = "repositories-token"
+	registryPassword = "registry-password"
+
+	s.checkTokens("packages-token", "repositories-token", "registry-password", false)
+}
+
+func (s *ClientsTestSuite) TestGithubRegistryKeychain() {
+	// The credentials of the GitHub registry are also valid for the GitHub API.
+	s.checkTokens("ghcr-keychain-password", "ghcr-keychain-password", "ghcr-keychain-password", true)
+}
+
+func (s *ClientsTestSuite) TestKeychainPasswordRedacted() {
+	var out bytes.Buffer
+	logWriter = newRedactingWriter(&out)
+	defer func() { logWriter = nil }()
+
+	s.checkTokens("ghcr-keychain-password", "ghcr-keychain-password", "ghcr-keychain-password", true)
+
+	// The password read from the keychain is masked in the logs.
+	_, err := logWriter.Write([]byte("password=ghcr-keychain-password"))
+	r := s.Require()
+	r.NoError(err)
+	r.Equal("password=[REDACTED]", out.String())
+}
+
+func (s *ClientsTestSuite) TestGithubEnterpriseRegistry() {
+	githubAPIURL = "https://api.github.example.com"
+	registry = "https://containers.github.example.com/"
+	password = "password"
+
+	s.checkTokens("password", "password", "password", false)
+}
+
+func (s *ClientsTestSuite) TestOtherRegistryDedicatedTokens() {
+	registry = "registry.example.com"
+	backend = pkg.RegistryBackend
+	password = "password"
+	repositoriesToken = "repositories-token"
+	registryPassword = "registry-password"
+
+	// The password, e.g. the GITHUB_TOKEN, is never sent to the other registry.
+	s.checkTokens("repositories-token", "repositories-token", "registry-password", false)
+}
+
+func (s *ClientsTestSuite) TestOtherRegistryKeychain() {
+	registry = "registry.example.com"
+	backend = pkg.RegistryBackend
+	repositoriesToken = "repositories-token"
+
+	// The credentials of the other registry are never sent to GitHub.
+	s.checkTokens("repositories-token", "repositories-token", "example-keychain-password", true)
+}
+
+func (s *ClientsTestSuite) TestOtherRegistryMissingTokens() {
+	registry = "registry.example.com"
+	backend = pkg.RegistryBackend
+	password = "password"
+
+	r := s.Require()
+	_, err := getClientTokenSources(context.Background())
+	r.ErrorContains(err, "the repositories token must be set, as the registry 'registry.example.com' is not hosted by GitHub")
+
+	repositoriesToken = "repositories-token"
+	registry = "registry-without-credentials.example.com"
+	_, err = getClientTokenSources(context.Background())
+	r.ErrorContains(err, "the registry password must be set, as the registry 'registry-without-credentials.example.com' is not hosted by GitHub")
+
+	backend = pkg.GithubBackend
+	_, err = getClientTokenSources(context.Background())
+	r.ErrorContains(err, "the packages token must be set")
+}
+
+func (s *RateLimitsTestSuite) TestMissingRateLimits() {
+	ghClient := &rateLimitsClient{rateLimits: pkg.RateLimits{
+		Packages: &github.Rate{Limit: 5000, Remaining: 4999, Reset: github.Timestamp{Time: time.Now()}},
+	}}
+
+	// The missing rate limit of the repositories token is skipped.
+	s.Require().NotPanics(func() { logRateLimits(context.Background(), ghClient) })
+}
+
+//
+// Helpers.
+//
+
+// rateLimitsClient is a GitHub client only returning rate limits.
+type rateLimitsClient struct {
+	pkg.GithubClient
+	rateLimits pkg.RateLimits
+}
+
+func (c *rateLimitsClient) GetRateLimits(context.Context) (pkg.RateLimits, error) {
+	return c.rateLimits, nil
+}
+
+// checkTokens checks the token received by each host.
+func (s *ClientsTestSuite) checkTokens(expectedPackagesToken, expectedRepositoriesToken, expectedRegistryToken string, useKeychain bool) {
+	r := s.Require()
+	tokenSources, err := getClientTokenSources(context.Background())
+	r.NoError(err)
+
+	getToken := func(tokenSource oauth2.TokenSource) string {
+		r.NotNil(tokenSource)
+		token, err := tokenSource.Token()
+		r.NoError(err)
+		return token.AccessToken
+	}
+	r.Equal(expectedPackagesToken, getToken(tokenSources.packages), "packages API token")
+	r.Equal(expectedRepositoriesToken, getToken(tokenSources.repositories), "repositories API token")
+	r.Equal(expectedRegistryToken, getToken(tokenSources.registry), "registry token")
+	r.Equal(useKeychain, tokenSources.useKeychain)
+}
@@ -1,35 +1,255 @@
 package pkg
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-github/v49/github"
 	"github.com/rs/zerolog/log"
-	"regexp"
-	"strconv"
+	"sort"
+	"strings"
+	"time"
 )
 
-const DefaultPrTagPattern = "^pr-(\\d+).*"
+// DefaultRegistry is the host of the GitHub Container registry.
+const DefaultRegistry = "ghcr.io"
 
-type PullRequestFilterParams struct {
-	Owner      string
-	Repository string
-	TagRegex   *regexp.Regexp
-}
+// The backends listing the package versions.
+const (
+	// GithubBackend lists the package versions with the GitHub Packages API, only available for the GitHub Container
+	// registry.
+	GithubBackend = "github"
+
+	// RegistryBackend lists the package versions with the OCI distribution API, available for any registry.
+	RegistryBackend = "registry"
+)
+
+// The strategies to delete the package versions.
+const (
+	// GithubDeletion deletes the package versions with the GitHub Packages API.
+	GithubDeletion = "github"
+
+	// RegistryDeletion deletes the registry objects with a manifest DELETE request.
+	RegistryDeletion = "registry"
+
+	// BothDeletion deletes the package versions with the GitHub Packages API, and falls back on a manifest DELETE
+	// request if it fails.
+	BothDeletion = "both"
+)
 
 type PackageRegistryParams struct {
 	Registry    string
 	User        string
 	PackageName string
+
+	// Backend is the backend listing the package versions, GithubBackend if empty.
+	Backend string
+
+	// DeletionStrategy is the strategy to delete the package versions, if empty the one of the backend: GithubDeletion
+	// for the GitHub backend and RegistryDeletion for the registry backend.
+	DeletionStrategy string
+
+	// PrunedPlatforms are the platforms (format os/architecture[/variant]) removed from the kept image indices, whose
+	// manifests are then deleted if no longer referenced.
+	PrunedPlatforms []string
+}
+
+// getRepository returns the repository of the package in the container registry, `REGISTRY/OWNER/PACKAGE` for both the
+// GitHub Container registry and the one of a GitHub Enterprise Server. The registry may be set as a URL, and the
+// repository is lowercase as required by the registries.
+func (p PackageRegistryParams) getRepository() string {
+	registry := strings.TrimPrefix(strings.TrimPrefix(p.Registry, "https://"), "http://")
+	registry = strings.TrimSuffix(registry, "/")
+	return strings.ToLower(fmt.Sprintf("%s/%s/%s", registry, p.User, p.PackageName))
+}
+
+// getDeletionStrategy returns the strategy to delete the package versions.
+func (p PackageRegistryParams) getDeletionStrategy() string {
+	if p.DeletionStrategy != "" {
+		return p.DeletionStrategy
+	}
+	if p.Backend == RegistryBackend {
+		return RegistryDeletion
+	}
+	return GithubDeletion
+}
+
+func Clean(ctx context.Context, ghClient GithubClient, prFilterParams PullRequestFilterParams, commitFilterParams CommitFilterParams, labelFilterParams LabelFilterParams, regClient ContainerRegistryClient, pkgRegistryParams PackageRegistryParams, dryRun bool) (*Plan, error) {
+	// The package versions listed by the registry backend cannot be deleted with the GitHub Packages API.
+	if pkgRegistryParams.Backend == RegistryBackend && pkgRegistryParams.getDeletionStrategy() != RegistryDeletion {
+		return nil, fmt.Errorf("the '%s' deletion strategy is not supported by the registry backend", pkgRegistryParams.getDeletionStrategy())
+	}
+
+	prunedPlatforms, err := parsePlatforms(pkgRegistryParams.PrunedPlatforms)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the permissions before doing anything.
+	log.Debug().Msg("performing the preflight check")
+	err = Preflight(ctx, ghClient, prFilterParams, commitFilterParams, labelFilterParams, pkgRegistryParams, dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	// List the package versions and get their registry object (image or image index).
+	repository := pkgRegistryParams.getRepository()
+	packageVersionByHash, imageByHash, indexByHash, err := fetchObjects(ctx, ghClient, regClient, pkgRegistryParams, repository)
+	if err != nil {
+		return nil, err
+	}
+
+	// Determine the hashes to delete.
+	plan, items, err := evaluatePackage(ctx, ghClient, prFilterParams, commitFilterParams, labelFilterParams, packageVersionByHash, imageByHash, indexByHash, evaluationOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to compute the cleaning plan: %w", err)
+	}
+
+	// Prune the platforms of the kept image indices, then determine the hashes to delete again, as the old indices and
+	// the manifests of the pruned platforms may no longer be referenced. Only the rewritten indices, whose tags have
+	// moved, are checked again: the checks of the other items are reused.
+	if len(prunedPlatforms) > 0 {
+		rewrites, prunedVersionByHash, prunedIndexByHash, err := pruneIndexPlatforms(ctx, regClient, repository, prunedPlatforms, plan, items, packageVersionByHash, indexByHash, dryRun)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(rewrites) > 0 {
+			packageVersionByHash, indexByHash = prunedVersionByHash, prunedIndexByHash
+			for _, rewrite := range rewrites {
+				delete(items, rewrite.OldHash)
+				delete(items, rewrite.NewHash)
+			}
+
+			plan, _, err = evaluatePackage(ctx, ghClient, prFilterParams, commitFilterParams, labelFilterParams, packageVersionByHash, imageByHash, indexByHash, evaluationOptions{previousItems: items})
+			if err != nil {
+				return nil, fmt.Errorf("unable to compute the cleaning plan: %w", err)
+			}
+			plan.Rewrites = rewrites
+		}
+	}
+
+	for _, decision := range plan.Decisions {
+		log.Debug().Str("hash", decision.Hash).Strs("tags", decision.Tags).Bool("delete", decision.Delete).Str("reason", decision.Reason).Str("provenance", decision.Provenance).Msg("decision taken")
+	}
+	toDelete := plan.HashesToDelete()
+
+	// Delete them.
+	if !dryRun {
+		// No dry run, perform the deletion.
+		nbDeleted := 0
+		for _, decision := range plan.Decisions {
+			if !decision.Delete {
+				continue
+			}
+
+			// Stop if the run has been cancelled, the remaining versions are left untouched.
+			if ctx.Err() != nil {
+				return plan, fmt.Errorf("registry cleaning interrupted, %d out of %d package version(s) deleted: %w", nbDeleted, len(toDelete), ctx.Err())
+			}
+
+			deletedBy, err := deletePackageVersion(ctx, ghClient, regClient, pkgRegistryParams, repository, packageVersionByHash[decision.Hash])
+			if err != nil {
+				log.Warn().Err(err).Msg("unable to delete package version")
+				continue
+			}
+			log.Info().Str("hash", decision.Hash).Str("deleted-by", deletedBy).Msg("package version deleted")
+			decision.Deleted = true
+			decision.DeletedBy = deletedBy
+			nbDeleted++
+		}
+
+		log.Info().Int("nb-deleted", nbDeleted).Msg("registry cleaning done")
+
+		// Check if all objects have been deleted.
+		if nbDeleted != len(toDelete) {
+			return plan, errors.New("one or more hash(es) could not be deleted")
+		}
+	} else {
+		// Dry run mode, don't perform the deletion.
+		log.Info().Msg("dry run mode is ON, no deletion has been performed")
+	}
+
+	return plan, nil
+}
+
+// Evaluate computes the cleaning plan of a package like Clean does, but without deleting anything.
+func Evaluate(ctx context.Context, ghClient GithubClient, prFilterParams PullRequestFilterParams, commitFilterParams CommitFilterParams, labelFilterParams LabelFilterParams, regClient ContainerRegistryClient, pkgRegistryParams PackageRegistryParams) (*Plan, error) {
+	// Check the permissions before doing anything, nothing is deleted.
+	log.Debug().Msg("performing the preflight check")
+	err := Preflight(ctx, ghClient, prFilterParams, commitFilterParams, labelFilterParams, pkgRegistryParams, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// List the package versions and get their registry object (image or image index).
+	packageVersionByHash, imageByHash, indexByHash, err := fetchObjects(ctx, ghClient, regClient, pkgRegistryParams, pkgRegistryParams.getRepository())
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := computePlan(ctx, ghClient, prFilterParams, commitFilterParams, labelFilterParams, packageVersionByHash, imageByHash, indexByHash)
+	if err != nil {
+		return nil, fmt.Errorf("unable to compute the cleaning plan: %w", err)
+	}
+	return plan, nil
+}
+
+// deletePackageVersion deletes a package version according to the deletion strategy, and returns the way it has been
+// deleted: GithubDeletion or RegistryDeletion.
+func deletePackageVersion(ctx context.Context, ghClient GithubClient, regClient ContainerRegistryClient, pkgRegistryParams PackageRegistryParams, repository string, version *github.PackageVersion) (string, error) {
+	hash := version.GetName()
+	strategy := pkgRegistryParams.getDeletionStrategy()
+
+	// Delete the package version with the GitHub Packages API.
+	if strategy == GithubDeletion || strategy == BothDeletion {
+		log.Trace().Str("hash", hash).Int64("version-id", version.GetID()).Msg("deleting package version")
+		err := ghClient.DeleteContainerPackageVersion(ctx, pkgRegistryParams.User, pkgRegistryParams.PackageName, version.GetID())
+		if err == nil {
+			return GithubDeletion, nil
+		}
+		if strategy == GithubDeletion || ctx.Err() != nil {
+			return "", err
+		}
+
+		log.Warn().Err(err).Str("hash", hash).Msg("unable to delete package version, falling back on the registry deletion")
+	}
+
+	// Delete the registry object.
+	log.Trace().Str("hash", hash).Msg("deleting container registry object")
+	err := regClient.DeleteRegistryObject(ctx, repository, hash)
+	if err != nil {
+		return "", err
+	}
+	return RegistryDeletion, nil
+}
+
+// fetchObjects lists the versions of a package and gets their registry object, with the backend of the package.
+func fetchObjects(ctx context.Context, ghClient GithubClient, regClient ContainerRegistryClient, pkgRegistryParams PackageRegistryParams, repository string) (
+	map[string]*github.PackageVersion,
+	map[string]v1.Image,
+	map[string]v1.ImageIndex,
+	error,
+) {
+	if pkgRegistryParams.Backend == RegistryBackend {
+		return discoverRegistryObjects(ctx, regClient, repository)
+	}
+	return fetchPackageObjects(ctx, ghClient, regClient, pkgRegistryParams, repository)
 }
 
-func Clean(ghClient GithubClient, prFilterParams PullRequestFilterParams, regClient ContainerRegistryClient, pkgRegistryParams PackageRegistryParams, dryRun bool) error {
+// fetchPackageObjects lists the versions of a package with the GitHub Packages API, and gets their registry object.
+func fetchPackageObjects(ctx context.Context, ghClient GithubClient, regClient ContainerRegistryClient, pkgRegistryParams PackageRegistryParams, repository string) (
+	map[string]*github.PackageVersion,
+	map[string]v1.Image,
+	map[string]v1.ImageIndex,
+	error,
+) {
 	// List all the versions of the package.
 	log.Debug().Str("user", pkgRegistryParams.User).Str("package", pkgRegistryParams.PackageName).Msg("listing all the package versions")
-	pkgVersions, err := ghClient.GetAllContainerPackageVersions(pkgRegistryParams.User, pkgRegistryParams.PackageName)
+	pkgVersions, err := ghClient.GetAllContainerPackageVersions(ctx, pkgRegistryParams.User, pkgRegistryParams.PackageName)
 	if err != nil {
-		return fmt.Errorf("unable to list the package versions: %w", err)
+		return nil, nil, nil, fmt.Errorf("unable to list the package versions: %w", err)
 	}
 
 	packageVersionByHash := make(map[string]*github.PackageVersion)
@@ -38,16 +258,24 @@ func Clean(ghClient GithubClient, prFilterParams PullRequestFilterParams, regCli
 	}
 
 	// Get the registry object (image or image index) for each hash.
-	repository := fmt.Sprintf("%s/%s/%s", pkgRegistryParams.Registry, pkgRegistryParams.User, pkgRegistryParams.PackageName)
 	log.Debug().Str("repository", repository).Msg("fetching the container registry objects")
 	imageByHash := make(map[string]v1.Image)
 	indexByHash := make(map[string]v1.ImageIndex)
 	for hash := range packageVersionByHash {
+		// Stop if the run has been cancelled.
+		if ctx.Err() != nil {
+			return nil, nil, nil, fmt.Errorf("unable to fetch the container registry objects: %w", ctx.Err())
+		}
+
 		log.Trace().Str("hash", hash).Msg("fetching container registry object")
 
 		// Get the container registry object.
-		image, index, err := regClient.GetRegistryObjectFromHash(repository, hash)
+		image, index, err := regClient.GetRegistryObjectFromHash(ctx, repository, hash)
 		if err != nil {
+			// A missing object would make the plan inconsistent, stop if the run has been cancelled.
+			if ctx.Err() != nil {
+				return nil, nil, nil, fmt.Errorf("unable to fetch the container registry objects: %w", ctx.Err())
+			}
 			log.Warn().Err(err).Msg("unable to retrieve container registry object")
 			continue
 		}
@@ -63,180 +291,530 @@ func Clean(ghClient GithubClient, prFilterParams PullRequestFilterParams, regCli
 		}
 	}
 
-	// Determine the hashes to delete.
-	toDelete, err := computeHashesToDelete(ghClient, prFilterParams, packageVersionByHash, imageByHash, indexByHash)
-	if err != nil {
-		return fmt.Errorf("unable to compute the hashes to delete: %w", err)
-	}
+	return packageVersionByHash, imageByHash, indexByHash, nil
+}
 
-	// Delete them.
-	if !dryRun {
-		// No dry run, perform the deletion.
-		nbDeleted := 0
-		for _, hash := range toDelete {
-			version := packageVersionByHash[hash]
-			log.Trace().Str("hash", hash).Int64("version-id", *version.ID).Msg("deleting package version")
-			err := ghClient.DeleteContainerPackageVersion(pkgRegistryParams.User, pkgRegistryParams.PackageName, *version.ID)
-			if err != nil {
-				log.Warn().Err(err).Msg("unable to delete package version")
-				continue
-			}
-			nbDeleted++
-		}
+// registryItem is a registry object of the package being evaluated, with its references to the other objects.
+type registryItem struct {
+	hash            string
+	referencedCount int
+	references      []*registryItem
+	mustKeep        bool
+	reason          string
 
-		log.Info().Int("nb-deleted", nbDeleted).Msg("registry cleaning done")
+	// The hashes of the objects referencing the item: the image indices, or the subject of a referrer.
+	parents []string
 
-		// Check if all objects have been deleted.
-		if nbDeleted != len(toDelete) {
-			return errors.New("one or more hash(es) could not be deleted")
-		}
-	} else {
-		// Dry run mode, don't perform the deletion.
-		log.Info().Msg("dry run mode is ON, no deletion has been performed")
+	// Whether the item refers to a subject, instead of being referenced by an image index.
+	referrer bool
+
+	// The verdicts of the policies on the item.
+	verdicts []Verdict
+
+	// Whether the item is protected by a keep marker.
+	keepMarker bool
+
+	// The revision of an untagged image, checked once it is known that the image has no parent.
+	revision *imageRevision
+}
+
+// reused returns a new item with the result of the checks of the item, but without its references.
+func (item *registryItem) reused() *registryItem {
+	return &registryItem{
+		mustKeep:   item.mustKeep,
+		reason:     item.reason,
+		verdicts:   item.verdicts,
+		keepMarker: item.keepMarker,
 	}
+}
 
-	return nil
+// computePlan computes the cleaning plan of a package from its versions and their registry objects.
+func computePlan(
+	ctx context.Context,
+	ghClient GithubClient,
+	prFilterParams PullRequestFilterParams,
+	commitFilterParams CommitFilterParams,
+	labelFilterParams LabelFilterParams,
+	packageVersionByHash map[string]*github.PackageVersion,
+	imageByHash map[string]v1.Image,
+	indexByHash map[string]v1.ImageIndex) (*Plan, error) {
+	plan, _, err := evaluatePackage(ctx, ghClient, prFilterParams, commitFilterParams, labelFilterParams, packageVersionByHash, imageByHash, indexByHash, evaluationOptions{})
+	return plan, err
 }
 
-func computeHashesToDelete(
+// evaluationOptions are the options of the evaluation of a package.
+type evaluationOptions struct {
+	// The items evaluated previously: their checks are not performed again, their result is reused.
+	previousItems map[string]*registryItem
+
+	// Whether all the tags of an item are checked to explain the decision, instead of stopping at the first valid one.
+	allVerdicts bool
+}
+
+// evaluatePackage computes the cleaning plan of a package, and returns it along with the evaluated registry items by
+// hash.
+func evaluatePackage(
+	ctx context.Context,
 	ghClient GithubClient,
 	prFilterParams PullRequestFilterParams,
+	commitFilterParams CommitFilterParams,
+	labelFilterParams LabelFilterParams,
 	packageVersionByHash map[string]*github.PackageVersion,
 	imageByHash map[string]v1.Image,
-	indexByHash map[string]v1.ImageIndex) ([]string, error) {
-	// Create a tree of the registry items.
-	type RegistryItem struct {
-		referencedCount int
-		references      []*RegistryItem
-		mustKeep        bool
-	}
+	indexByHash map[string]v1.ImageIndex,
+	options evaluationOptions) (*Plan, map[string]*registryItem, error) {
+	// Create the commit reachability and revision checkers, shared by all the items to benefit from their cache.
+	commits := newCommitChecker(ghClient, commitFilterParams)
+	revisions := newRevisionChecker(ghClient, commits)
 
-	items := make(map[string]*RegistryItem)
+	// Create a tree of the registry items.
+	items := make(map[string]*registryItem)
+	now := time.Now()
 
 	// Add the images.
-	for hash := range imageByHash {
-		items[hash] = &RegistryItem{
+	for hash, image := range imageByHash {
+		// Stop if the run has been cancelled.
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+
+		// Reuse the previous checks, if any.
+		if previous, found := options.previousItems[hash]; found {
+			items[hash] = previous.reused()
+			continue
+		}
+
+		tags := packageVersionByHash[hash].Metadata.Container.Tags
+
+		// Get the image labels and annotations.
+		labels, err := getImageLabels(image)
+		if err != nil {
+			log.Warn().Err(err).Str("hash", hash).Msg("unable to retrieve the image labels")
+		}
+		annotations, err := getImageAnnotations(image)
+		if err != nil {
+			log.Warn().Err(err).Str("hash", hash).Msg("unable to retrieve the image annotations")
+		}
+
+		// Check if the image is protected by a keep marker.
+		reason := getKeepReason(labels, "label", now)
+		if reason == "" {
+			reason = getKeepReason(annotations, "annotation", now)
+		}
+		if reason != "" {
+			items[hash] = &registryItem{mustKeep: true, reason: reason, verdicts: []Verdict{{Policy: "keep marker", Verdict: "keep", Detail: reason}}, keepMarker: true}
+			continue
+		}
+
+		// Get the revision from the image labels.
+		var revision *imageRevision
+		if labelFilterParams.Enabled {
+			revision = getRevision(labels, prFilterParams.Owner, prFilterParams.Repository)
+		}
+
+		mustKeep, reason, verdicts := hasValidTags(ctx, ghClient, prFilterParams, commits, revisions, tags, revision, options.allVerdicts)
+		items[hash] = &registryItem{
 			referencedCount: 0,
 			references:      nil,
-			mustKeep:        hasValidTags(ghClient, prFilterParams, packageVersionByHash[hash].Metadata.Container.Tags),
+			mustKeep:        mustKeep,
+			reason:          reason,
+			verdicts:        verdicts,
+		}
+		if len(tags) == 0 {
+			items[hash].revision = revision
 		}
 	}
 
 	// Add the image indices.
-	for hash := range indexByHash {
-		items[hash] = &RegistryItem{
+	for hash, index := range indexByHash {
+		// Stop if the run has been cancelled.
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+
+		// Reuse the previous checks, if any.
+		if previous, found := options.previousItems[hash]; found {
+			items[hash] = previous.reused()
+			continue
+		}
+
+		tags := packageVersionByHash[hash].Metadata.Container.Tags
+
+		// Check if the image index is protected by a keep marker.
+		indexManifest, err := index.IndexManifest()
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to get the image index manifest: %w", err)
+		}
+		if reason := getKeepReason(indexManifest.Annotations, "annotation", now); reason != "" {
+			items[hash] = &registryItem{mustKeep: true, reason: reason, verdicts: []Verdict{{Policy: "keep marker", Verdict: "keep", Detail: reason}}, keepMarker: true}
+			continue
+		}
+
+		mustKeep, reason, verdicts := hasValidTags(ctx, ghClient, prFilterParams, commits, revisions, tags, nil, options.allVerdicts)
+		items[hash] = &registryItem{
 			referencedCount: 0,
 			references:      nil,
-			mustKeep:        hasValidTags(ghClient, prFilterParams, packageVersionByHash[hash].Metadata.Container.Tags),
+			mustKeep:        mustKeep,
+			reason:          reason,
+			verdicts:        verdicts,
 		}
 	}
 
-	// Add the references.
+	// The checks of the last items may have failed because the run has been cancelled.
+	if ctx.Err() != nil {
+		return nil, nil, ctx.Err()
+	}
+
+	// Add the references, and keep track of the objects referred to by each object to order the deletions and to
+	// describe the graph in the plan.
+	referredHashesByHash := make(map[string][]string)
+	manifestsByHash := make(map[string][]string)
+	subjectByHash := make(map[string]string)
 	for hash, index := range indexByHash {
 		indexManifest, err := index.IndexManifest()
 		if err != nil {
-			return nil, fmt.Errorf("unable to get the image index manifest: %w", err)
+			return nil, nil, fmt.Errorf("unable to get the image index manifest: %w", err)
 		}
 
+		seen := make(map[string]bool)
 		for _, manifest := range indexManifest.Manifests {
-			// Get the referenced item.
+			// An index may reference the same manifest several times (e.g. for two platforms), add a single reference.
 			referencedHash := manifest.Digest.String()
-			referencedItem := items[referencedHash]
+			if seen[referencedHash] {
+				continue
+			}
+			seen[referencedHash] = true
+
+			// Get the referenced item, it may be missing if its registry object could not be retrieved.
+			referencedItem, found := items[referencedHash]
+			if !found {
+				log.Debug().Str("hash", hash).Str("referenced-hash", referencedHash).Msg("unknown object referenced by the image index")
+				continue
+			}
 
 			// Add it to the current item references.
 			items[hash].references = append(items[hash].references, referencedItem)
+			referredHashesByHash[hash] = append(referredHashesByHash[hash], referencedHash)
+			manifestsByHash[hash] = append(manifestsByHash[hash], referencedHash)
 
 			// Increment the references counter on the referenced item.
 			referencedItem.referencedCount++
+			referencedItem.parents = append(referencedItem.parents, hash)
+		}
+	}
+
+	// Add the references from the subjects to their referrers (e.g. signatures and attestations), so that the referrers
+	// are kept as long as their subject.
+	for hash, subject := range getSubjects(imageByHash, indexByHash) {
+		subjectItem, found := items[subject]
+		if !found || items[hash] == nil {
+			continue
 		}
+
+		subjectItem.references = append(subjectItem.references, items[hash])
+		referredHashesByHash[hash] = append(referredHashesByHash[hash], subject)
+		subjectByHash[hash] = subject
+		items[hash].referencedCount++
+		items[hash].referrer = true
+		items[hash].parents = append(items[hash].parents, subject)
 	}
 
-	// Identify the items to be deleted.
-	toDelete := make(map[string]struct{})
+	// Decide the untagged images without any parent from their revision, if known.
+	for hash, item := range items {
+		if item.revision == nil || item.referencedCount > 0 {
+			continue
+		}
+
+		// Stop if the run has been cancelled.
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
 
-	nPass := 0
-	for {
-		nPass++
-		nMarkedToDelete := 0
+		checkUntaggedRevision(ctx, revisions, hash, item)
+	}
 
-		for hash, item := range items {
-			if item.referencedCount == 0 && !item.mustKeep {
-				// The current item can be deleted.
-				delete(items, hash)
-				toDelete[hash] = struct{}{}
-				nMarkedToDelete++
+	// Identify the items to be deleted, starting with the ones referenced by no other item and following the references
+	// of the deleted items, so that each item and each reference is visited once.
+	plan := &Plan{}
+	var queue []*registryItem
+	for hash, item := range items {
+		item.hash = hash
+		if item.referencedCount == 0 && !item.mustKeep {
+			queue = append(queue, item)
+		}
+	}
 
-				// Decrement the referenced count in all the referenced items.
-				for _, ref := range item.references {
-					ref.referencedCount--
-				}
+	deleted := make(map[string]bool)
+	for len(queue) > 0 {
+		item := queue[0]
+		queue = queue[1:]
+
+		// The current item can be deleted.
+		deleted[item.hash] = true
+		plan.Decisions = append(plan.Decisions, &Decision{
+			Hash:       item.hash,
+			Tags:       packageVersionByHash[item.hash].Metadata.Container.Tags,
+			Delete:     true,
+			Reason:     item.reason,
+			Provenance: getDeletionProvenance(item.parents, item.referrer),
+		})
+
+		// Decrement the referenced count in all the referenced items, the ones no longer referenced can be deleted too.
+		for _, ref := range item.references {
+			ref.referencedCount--
+			if ref.referencedCount == 0 && !ref.mustKeep {
+				queue = append(queue, ref)
 			}
 		}
+	}
 
-		log.Debug().Int("pass", nPass).Int("nb-marked-to-delete", nMarkedToDelete).Send()
+	log.Debug().Int("nb-marked-to-delete", len(deleted)).Int("nb-items", len(items)).Msg("registry items evaluated")
 
-		if nMarkedToDelete == 0 {
-			// Done, there is no more items to mark for deletion.
-			break
+	// Add the decisions for the kept items.
+	for hash, item := range items {
+		if deleted[hash] {
+			continue
+		}
+
+		reason := item.reason
+		provenance := ""
+		if !item.mustKeep {
+			reason = "referenced by a kept image index"
+			if item.referrer {
+				reason = "referrer of a kept object"
+			}
+			provenance = getKeepProvenance(item.parents, deleted, item.referrer)
 		}
+
+		plan.Decisions = append(plan.Decisions, &Decision{
+			Hash:       hash,
+			Tags:       packageVersionByHash[hash].Metadata.Container.Tags,
+			Delete:     false,
+			Reason:     reason,
+			Provenance: provenance,
+		})
 	}
 
-	// Return a slice of the hashes to delete.
-	var ret []string
-	for hash := range toDelete {
-		ret = append(ret, hash)
+	// Sort the decisions in deletion order, to have a reproducible plan and to never leave a dangling reference if the
+	// cleaning is interrupted.
+	sortDecisions(plan.Decisions, referredHashesByHash)
+
+	for _, decision := range plan.Decisions {
+		if manifests, found := manifestsByHash[decision.Hash]; found {
+			decision.Manifests = sortedCopy(manifests)
+		}
+		decision.Subject = subjectByHash[decision.Hash]
 	}
-	return ret, nil
+
+	return plan, items, nil
 }
 
-func hasValidTags(ghClient GithubClient, prFilterParams PullRequestFilterParams, tags []string) bool {
+// getDeletionProvenance returns how the deletion of an item derives from the deletion of its parents, or an empty
+// string if it has no parents.
+func getDeletionProvenance(parents []string, referrer bool) string {
+	parents = sortedCopy(parents)
+	switch {
+	case len(parents) == 0:
+		return ""
+	case referrer:
+		return fmt.Sprintf("deleted because its subject %s was deleted", parents[0])
+	case len(parents) == 1:
+		return fmt.Sprintf("deleted because its only parent %s was deleted", parents[0])
+	default:
+		return fmt.Sprintf("deleted because all its parents were deleted: %s", strings.Join(parents, ", "))
+	}
+}
+
+// getKeepProvenance returns how the keeping of an item derives from the keeping of one of its parents, the first one by
+// hash if several are kept.
+func getKeepProvenance(parents []string, deleted map[string]bool, referrer bool) string {
+	for _, parent := range sortedCopy(parents) {
+		if deleted[parent] {
+			continue
+		}
+
+		if referrer {
+			return fmt.Sprintf("kept because its subject %s is kept", parent)
+		}
+		return fmt.Sprintf("kept because its parent %s is kept", parent)
+	}
+	return ""
+}
+
+// sortedCopy returns a sorted copy of a list of strings.
+func sortedCopy(values []string) []string {
+	sorted := append([]string{}, values...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// getSubjects returns the hash of the subject of each object referring to another one, as set by the `subject` field
+// of its manifest.
+func getSubjects(imageByHash map[string]v1.Image, indexByHash map[string]v1.ImageIndex) map[string]string {
+	subjectByHash := make(map[string]string)
+
+	for hash, image := range imageByHash {
+		manifest, err := image.Manifest()
+		if err != nil {
+			log.Warn().Err(err).Str("hash", hash).Msg("unable to get the image manifest")
+			continue
+		}
+		if manifest != nil && manifest.Subject != nil {
+			subjectByHash[hash] = manifest.Subject.Digest.String()
+		}
+	}
+
+	for hash, index := range indexByHash {
+		indexManifest, err := index.IndexManifest()
+		if err != nil {
+			log.Warn().Err(err).Str("hash", hash).Msg("unable to get the image index manifest")
+			continue
+		}
+		if indexManifest != nil && indexManifest.Subject != nil {
+			subjectByHash[hash] = indexManifest.Subject.Digest.String()
+		}
+	}
+
+	return subjectByHash
+}
+
+func hasValidTags(ctx context.Context, ghClient GithubClient, prFilterParams PullRequestFilterParams, commits *commitChecker, revisions *revisionChecker, tags []string, revision *imageRevision, allVerdicts bool) (bool, string, []Verdict) {
 	hasValidTags := true
+	reason := "valid tags"
+	var verdicts []Verdict
 
 	if len(tags) == 0 {
+		// The revision of an untagged image is checked once it is known whether the image has a parent.
 		hasValidTags = false
+		reason = "no tags"
 	} else {
-		// There are tags, check if they are related to a closed pull request.
-		isRelatedToClosedPR, err := checkTagsRelatedToClosedPullRequest(ghClient, prFilterParams, tags)
+		// There are tags, check if they are all obsolete.
+		allTagsObsolete, tagVerdicts, err := checkAllTagsObsolete(ctx, ghClient, prFilterParams, commits, revisions, tags, revision, allVerdicts)
+		verdicts = tagVerdicts
 		if err != nil {
 			// Error occurred, don't change the returned value as we don't want to delete this object.
-			log.Warn().Err(err).Msg("unable to check if a tag is related to a closed PR")
-		} else if isRelatedToClosedPR {
-			// All the tags are related to a closed pull request.
+			log.Warn().Err(err).Msg("unable to check if the tags are obsolete")
+			reason = "unable to check if the tags are obsolete"
+		} else if allTagsObsolete {
+			// All the tags are obsolete.
 			hasValidTags = false
+			reason = "all tags obsolete"
 		}
 	}
 
-	return hasValidTags
+	return hasValidTags, reason, verdicts
+}
+
+// checkUntaggedRevision decides an untagged image without any parent from its revision: the image is kept as long as
+// the revision is valid, or if it cannot be checked.
+func checkUntaggedRevision(ctx context.Context, revisions *revisionChecker, hash string, item *registryItem) {
+	verdict := Verdict{Policy: "revision", Subject: item.revision.sha}
+
+	obsolete, detail, err := revisions.isRevisionObsolete(ctx, *item.revision)
+	switch {
+	case err != nil:
+		log.Warn().Err(err).Str("hash", hash).Msg("unable to check if the revision is obsolete")
+		item.mustKeep = true
+		item.reason = "unable to check if the revision is obsolete"
+		item.verdicts = append(item.verdicts, verdict.withError(err))
+	case obsolete:
+		item.reason = fmt.Sprintf("no tags, obsolete revision '%s'", item.revision.sha)
+		item.verdicts = append(item.verdicts, verdict.with(true, detail))
+	default:
+		item.mustKeep = true
+		item.reason = fmt.Sprintf("no tags, valid revision '%s'", item.revision.sha)
+		item.verdicts = append(item.verdicts, verdict.with(false, detail))
+	}
 }
 
-func checkTagsRelatedToClosedPullRequest(ghClient GithubClient, prFilterParams PullRequestFilterParams, tags []string) (bool, error) {
-	// Check if all tags are related to a closed pull request.
-	allTagsRelatedToClosedPR := true
+// checkAllTagsObsolete returns whether all the tags are obsolete, along with the verdicts on the tags checked: the
+// check stops at the first tag which is not obsolete, unless all the verdicts are requested.
+func checkAllTagsObsolete(ctx context.Context, ghClient GithubClient, prFilterParams PullRequestFilterParams, commits *commitChecker, revisions *revisionChecker, tags []string, revision *imageRevision, allVerdicts bool) (bool, []Verdict, error) {
+	// Check if all tags are related to a closed pull request or to an unreachable commit.
+	allObsolete := true
+	var verdicts []Verdict
 	for _, tag := range tags {
-		matches := prFilterParams.TagRegex.FindStringSubmatch(tag)
-		if matches != nil {
-			// Get the pull request id.
-			idStr := matches[1]
-			id, err := strconv.Atoi(idStr)
-			if err != nil {
-				return false, fmt.Errorf("unable to parse pull request identifier '%s': %w", idStr, err)
+		obsolete, verdict, err := checkTagObsolete(ctx, ghClient, prFilterParams, commits, revisions, tag, revision)
+		verdicts = append(verdicts, verdict)
+		if err != nil {
+			return false, verdicts, err
+		}
+
+		if !obsolete {
+			allObsolete = false
+			if !allVerdicts {
+				break
 			}
+		}
+	}
 
-			// Get the pull request status.
-			status, err := ghClient.GetPullRequestState(prFilterParams.Owner, prFilterParams.Repository, id)
+	return allObsolete, verdicts, nil
+}
+
+// checkTagObsolete returns whether a tag is obsolete, along with the verdict of the policy the tag is related to.
+func checkTagObsolete(ctx context.Context, ghClient GithubClient, prFilterParams PullRequestFilterParams, commits *commitChecker, revisions *revisionChecker, tag string, revision *imageRevision) (bool, Verdict, error) {
+	// Check if the tag is related to a pull request.
+	if regex, matches := matchPullRequestTag(prFilterParams, tag); matches != nil {
+		verdict := Verdict{Policy: "pull request tag", Subject: tag}
+
+		// Get the pull request repository and id.
+		owner, repository, id, err := getPullRequestReference(prFilterParams, regex, matches)
+		if err != nil {
+			return false, verdict.withError(err), err
+		}
+
+		// Get the pull request status.
+		status, err := ghClient.GetPullRequestState(ctx, owner, repository, id)
+		if err != nil {
+			err = fmt.Errorf("unable to retrieve pull request status: %w", err)
+			return false, verdict.withError(err), err
+		}
+
+		verdict.PullRequest = &PullRequestState{Owner: owner, Repository: repository, Number: id, State: status}
+		return checkRevisionEvidence(ctx, revisions, revision, verdict, status == "closed", fmt.Sprintf("pull request %s/%s#%d is %s", owner, repository, id, status))
+	}
+
+	// Check if the tag is related to a commit.
+	if commits != nil && commits.params.TagRegex != nil {
+		matches := commits.params.TagRegex.FindStringSubmatch(tag)
+		if matches != nil {
+			verdict := Verdict{Policy: "commit tag", Subject: tag}
+
+			// Check if the commit is still reachable from a protected reference.
+			reachable, err := commits.isCommitReachable(ctx, matches[1])
 			if err != nil {
-				return false, fmt.Errorf("unable to retrieve pull request status: %w", err)
+				err = fmt.Errorf("unable to check the commit reachability: %w", err)
+				return false, verdict.withError(err), err
 			}
 
-			if status != "closed" {
-				allTagsRelatedToClosedPR = false
-				break
+			detail := fmt.Sprintf("commit %s is reachable from a protected reference", matches[1])
+			if !reachable {
+				detail = fmt.Sprintf("commit %s is not reachable from any protected reference", matches[1])
 			}
-		} else {
-			allTagsRelatedToClosedPR = false
-			break
+			return checkRevisionEvidence(ctx, revisions, revision, verdict, !reachable, detail)
 		}
 	}
 
-	return allTagsRelatedToClosedPR, nil
+	// Any other tag is valid, whatever the revision.
+	return false, Verdict{Policy: "tag", Subject: tag, Verdict: "valid", Detail: "not related to a pull request nor to a commit"}, nil
+}
+
+// checkRevisionEvidence completes the verdict on a pull request or commit tag with the image revision, if known: an
+// obsolete tag is still kept if the revision is associated to a pull request that is not closed. The revision never
+// makes a valid tag obsolete.
+func checkRevisionEvidence(ctx context.Context, revisions *revisionChecker, revision *imageRevision, verdict Verdict, obsolete bool, detail string) (bool, Verdict, error) {
+	if !obsolete || revision == nil {
+		return obsolete, verdict.with(obsolete, detail), nil
+	}
+
+	open, err := revisions.hasOpenPullRequest(ctx, *revision)
+	if err != nil {
+		err = fmt.Errorf("unable to check the pull requests of the revision: %w", err)
+		return false, verdict.withError(err), err
+	}
+
+	if open {
+		return false, verdict.with(false, fmt.Sprintf("%s, but revision %s is associated to an open pull request", detail, revision)), nil
+	}
+	return true, verdict.with(true, fmt.Sprintf("%s, revision %s is not associated to an open pull request", detail, revision)), nil
 }
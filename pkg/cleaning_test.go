@@ -1,7 +1,9 @@
 package pkg
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/fake"
 	"github.com/google/go-github/v49/github"
@@ -10,6 +12,7 @@ import (
 	"github.com/stretchr/testify/suite"
 	"regexp"
 	"testing"
+	"time"
 )
 
 //
@@ -41,8 +44,26 @@ const (
 	index2 = "sha256:627e7a284dd04d9532bab7897077668416c4912d85a08cb7988f8bc547fbc013"
 )
 
+var cleanPkgRegistryParams = PackageRegistryParams{
+	Registry:    "ghcr.io",
+	User:        "user",
+	PackageName: "package",
+}
+
+// The repository of the registry objects of cleanPkgRegistryParams.
+const cleanRepository = "ghcr.io/user/package"
+
 var defaultPrFilterParams = PullRequestFilterParams{
-	TagRegex: regexp.MustCompile(DefaultPrTagPattern),
+	TagRegexes: []*regexp.Regexp{regexp.MustCompile(DefaultPrTagPattern)},
+}
+
+var labelLookupFilterParams = LabelFilterParams{
+	Enabled: true,
+}
+
+var defaultCommitFilterParams = CommitFilterParams{
+	TagRegex:             regexp.MustCompile("^sha-([0-9a-f]+)$"),
+	ProtectedBranchRegex: regexp.MustCompile(DefaultProtectedBranchPattern),
 }
 
 //
@@ -53,25 +74,22 @@ type githubClientMock struct {
 	mock.Mock
 }
 
-func (m *githubClientMock) GetAllContainerPackages(user string) ([]*github.Package, error) {
+func (m *githubClientMock) GetAllContainerPackages(_ context.Context, user string) ([]*github.Package, error) {
 	_ = user
 	return nil, nil
 }
 
-func (m *githubClientMock) GetAllContainerPackageVersions(user, packageName string) ([]*github.PackageVersion, error) {
-	_ = user
-	_ = packageName
-	return nil, nil
+func (m *githubClientMock) GetAllContainerPackageVersions(_ context.Context, user, packageName string) ([]*github.PackageVersion, error) {
+	args := m.Called(user, packageName)
+	return args.Get(0).([]*github.PackageVersion), args.Error(1)
 }
 
-func (m *githubClientMock) DeleteContainerPackageVersion(user, packageName string, id int64) error {
-	_ = user
-	_ = packageName
-	_ = id
-	return nil
+func (m *githubClientMock) DeleteContainerPackageVersion(_ context.Context, user, packageName string, id int64) error {
+	args := m.Called(user, packageName, id)
+	return args.Error(0)
 }
 
-func (m *githubClientMock) GetPullRequestState(owner, repository string, id int) (string, error) {
+func (m *githubClientMock) GetPullRequestState(_ context.Context, owner, repository string, id int) (string, error) {
 	// Records that the method was called with its parameters.
 	args := m.Called(owner, repository, id)
 
@@ -79,41 +97,126 @@ func (m *githubClientMock) GetPullRequestState(owner, repository string, id int)
 	return args.String(0), args.Error(1)
 }
 
+func (m *githubClientMock) GetAllBranches(_ context.Context, owner, repository string) ([]*github.Branch, error) {
+	args := m.Called(owner, repository)
+	return args.Get(0).([]*github.Branch), args.Error(1)
+}
+
+func (m *githubClientMock) GetAllTags(_ context.Context, owner, repository string) ([]*github.RepositoryTag, error) {
+	args := m.Called(owner, repository)
+	return args.Get(0).([]*github.RepositoryTag), args.Error(1)
+}
+
+func (m *githubClientMock) GetCommitComparisonStatus(_ context.Context, owner, repository, base, head string) (string, error) {
+	args := m.Called(owner, repository, base, head)
+	return args.String(0), args.Error(1)
+}
+
+func (m *githubClientMock) GetAllPullRequestsForCommit(_ context.Context, owner, repository, sha string) ([]*github.PullRequest, error) {
+	args := m.Called(owner, repository, sha)
+	return args.Get(0).([]*github.PullRequest), args.Error(1)
+}
+
+func (m *githubClientMock) GetContainerPackage(_ context.Context, user, packageName string) (*github.Package, error) {
+	args := m.Called(user, packageName)
+	return args.Get(0).(*github.Package), args.Error(1)
+}
+
+func (m *githubClientMock) GetLatestContainerPackageVersion(_ context.Context, user, packageName string) (*github.PackageVersion, error) {
+	args := m.Called(user, packageName)
+	return args.Get(0).(*github.PackageVersion), args.Error(1)
+}
+
+func (m *githubClientMock) GetLatestPullRequest(_ context.Context, owner, repository string) (*github.PullRequest, error) {
+	args := m.Called(owner, repository)
+	return args.Get(0).(*github.PullRequest), args.Error(1)
+}
+
+func (m *githubClientMock) GetTokenScopes(_ context.Context) (TokenScopes, error) {
+	args := m.Called()
+	return args.Get(0).(TokenScopes), args.Error(1)
+}
+
+func (m *githubClientMock) GetRateLimits(_ context.Context) (RateLimits, error) {
+	args := m.Called()
+	return args.Get(0).(RateLimits), args.Error(1)
+}
+
+//
+// ContainerRegistryClient mock.
+//
+
+type registryClientMock struct {
+	mock.Mock
+}
+
+func (m *registryClientMock) GetRegistryObjectFromHash(_ context.Context, repository, hash string) (v1.Image, v1.ImageIndex, error) {
+	args := m.Called(repository, hash)
+	image, _ := args.Get(0).(v1.Image)
+	index, _ := args.Get(1).(v1.ImageIndex)
+	return image, index, args.Error(2)
+}
+
+func (m *registryClientMock) DeleteRegistryObject(_ context.Context, repository, hash string) error {
+	args := m.Called(repository, hash)
+	return args.Error(0)
+}
+
+func (m *registryClientMock) ListTags(_ context.Context, repository string) ([]string, error) {
+	args := m.Called(repository)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *registryClientMock) GetTagHash(_ context.Context, repository, tag string) (string, error) {
+	args := m.Called(repository, tag)
+	return args.String(0), args.Error(1)
+}
+
+func (m *registryClientMock) GetReferrers(_ context.Context, repository, hash string) ([]string, error) {
+	args := m.Called(repository, hash)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *registryClientMock) WriteIndex(_ context.Context, repository string, index v1.ImageIndex, tags []string) error {
+	args := m.Called(repository, index, tags)
+	return args.Error(0)
+}
+
 //
 // Tests.
 //
 
 func (s *CleaningTestSuite) TestImageNoTag() {
 	// Compute the hashes to delete.
-	versions, images, indices := s.buildTestData(map[string]TestDataItem{
+	versions, images, indices := buildTestData(map[string]TestDataItem{
 		image1: {tags: nil, references: nil},
 	})
 
-	toDelete, err := computeHashesToDelete(nil, PullRequestFilterParams{}, versions, images, indices)
+	plan, err := computePlan(context.Background(), nil, PullRequestFilterParams{}, CommitFilterParams{}, LabelFilterParams{}, versions, images, indices)
 
 	// Check the result.
 	r := s.Require()
 	r.NoError(err)
-	r.ElementsMatch(toDelete, []string{image1})
+	r.ElementsMatch(plan.HashesToDelete(), []string{image1})
 }
 
 func (s *CleaningTestSuite) TestImageValidTag() {
 	// Compute the hashes to delete.
-	versions, images, indices := s.buildTestData(map[string]TestDataItem{
+	versions, images, indices := buildTestData(map[string]TestDataItem{
 		image1: {tags: []string{"v1.2.3"}, references: nil},
 	})
 
-	toDelete, err := computeHashesToDelete(nil, defaultPrFilterParams, versions, images, indices)
+	plan, err := computePlan(context.Background(), nil, defaultPrFilterParams, CommitFilterParams{}, LabelFilterParams{}, versions, images, indices)
 
 	// Check the result.
 	r := s.Require()
 	r.NoError(err)
-	r.Empty(toDelete)
+	r.Empty(plan.HashesToDelete())
 }
 
 func (s *CleaningTestSuite) TestImageActivePullRequestTag() {
 	// Compute the hashes to delete.
-	versions, images, indices := s.buildTestData(map[string]TestDataItem{
+	versions, images, indices := buildTestData(map[string]TestDataItem{
 		image1: {tags: []string{"pr-1234"}, references: nil},
 	})
 
@@ -122,19 +225,19 @@ func (s *CleaningTestSuite) TestImageActivePullRequestTag() {
 		On("GetPullRequestState", defaultPrFilterParams.Owner, defaultPrFilterParams.Repository, 1234).
 		Return("active", nil)
 
-	toDelete, err := computeHashesToDelete(ghClient, defaultPrFilterParams, versions, images, indices)
+	plan, err := computePlan(context.Background(), ghClient, defaultPrFilterParams, CommitFilterParams{}, LabelFilterParams{}, versions, images, indices)
 
 	// Check the result.
 	ghClient.AssertExpectations(s.T())
 
 	r := s.Require()
 	r.NoError(err)
-	r.Empty(toDelete)
+	r.Empty(plan.HashesToDelete())
 }
 
 func (s *CleaningTestSuite) TestImageClosedPullRequestTag() {
 	// Compute the hashes to delete.
-	versions, images, indices := s.buildTestData(map[string]TestDataItem{
+	versions, images, indices := buildTestData(map[string]TestDataItem{
 		image1: {tags: []string{"pr-1234"}, references: nil},
 	})
 
@@ -143,19 +246,19 @@ func (s *CleaningTestSuite) TestImageClosedPullRequestTag() {
 		On("GetPullRequestState", defaultPrFilterParams.Owner, defaultPrFilterParams.Repository, 1234).
 		Return("closed", nil)
 
-	toDelete, err := computeHashesToDelete(ghClient, defaultPrFilterParams, versions, images, indices)
+	plan, err := computePlan(context.Background(), ghClient, defaultPrFilterParams, CommitFilterParams{}, LabelFilterParams{}, versions, images, indices)
 
 	// Check the result.
 	ghClient.AssertExpectations(s.T())
 
 	r := s.Require()
 	r.NoError(err)
-	r.ElementsMatch(toDelete, []string{image1})
+	r.ElementsMatch(plan.HashesToDelete(), []string{image1})
 }
 
 func (s *CleaningTestSuite) TestImageUnknownPullRequestTag() {
 	// Compute the hashes to delete.
-	versions, images, indices := s.buildTestData(map[string]TestDataItem{
+	versions, images, indices := buildTestData(map[string]TestDataItem{
 		image1: {tags: []string{"pr-1234"}, references: nil},
 	})
 
@@ -164,19 +267,19 @@ func (s *CleaningTestSuite) TestImageUnknownPullRequestTag() {
 		On("GetPullRequestState", defaultPrFilterParams.Owner, defaultPrFilterParams.Repository, 1234).
 		Return("", errors.New("not found"))
 
-	toDelete, err := computeHashesToDelete(ghClient, defaultPrFilterParams, versions, images, indices)
+	plan, err := computePlan(context.Background(), ghClient, defaultPrFilterParams, CommitFilterParams{}, LabelFilterParams{}, versions, images, indices)
 
 	// Check the result.
 	ghClient.AssertExpectations(s.T())
 
 	r := s.Require()
 	r.NoError(err)
-	r.Empty(toDelete)
+	r.Empty(plan.HashesToDelete())
 }
 
 func (s *CleaningTestSuite) TestImageMixedActiveAndClosedPullRequestsTag() {
 	// Compute the hashes to delete.
-	versions, images, indices := s.buildTestData(map[string]TestDataItem{
+	versions, images, indices := buildTestData(map[string]TestDataItem{
 		image1: {tags: []string{"pr-1234", "pr-5678"}, references: nil},
 	})
 
@@ -187,19 +290,19 @@ func (s *CleaningTestSuite) TestImageMixedActiveAndClosedPullRequestsTag() {
 		On("GetPullRequestState", defaultPrFilterParams.Owner, defaultPrFilterParams.Repository, 5678).
 		Return("active", nil)
 
-	toDelete, err := computeHashesToDelete(ghClient, defaultPrFilterParams, versions, images, indices)
+	plan, err := computePlan(context.Background(), ghClient, defaultPrFilterParams, CommitFilterParams{}, LabelFilterParams{}, versions, images, indices)
 
 	// Check the result.
 	ghClient.AssertExpectations(s.T())
 
 	r := s.Require()
 	r.NoError(err)
-	r.Empty(toDelete)
+	r.Empty(plan.HashesToDelete())
 }
 
 func (s *CleaningTestSuite) TestImageMixedValidTagAndClosedPullRequestsTag() {
 	// Compute the hashes to delete.
-	versions, images, indices := s.buildTestData(map[string]TestDataItem{
+	versions, images, indices := buildTestData(map[string]TestDataItem{
 		image1: {tags: []string{"pr-1234", "v1.2.3"}, references: nil},
 	})
 
@@ -208,128 +311,250 @@ func (s *CleaningTestSuite) TestImageMixedValidTagAndClosedPullRequestsTag() {
 		On("GetPullRequestState", defaultPrFilterParams.Owner, defaultPrFilterParams.Repository, 1234).
 		Return("closed", nil)
 
-	toDelete, err := computeHashesToDelete(ghClient, defaultPrFilterParams, versions, images, indices)
+	plan, err := computePlan(context.Background(), ghClient, defaultPrFilterParams, CommitFilterParams{}, LabelFilterParams{}, versions, images, indices)
+
+	// Check the result.
+	ghClient.AssertExpectations(s.T())
+
+	r := s.Require()
+	r.NoError(err)
+	r.Empty(plan.HashesToDelete())
+}
+
+func (s *CleaningTestSuite) TestImageValidTagStopsTheCheck() {
+	// Compute the hashes to delete.
+	versions, images, indices := buildTestData(map[string]TestDataItem{
+		image1: {tags: []string{"v1.2.3", "pr-1234"}, references: nil},
+	})
+
+	// The pull request is not looked up, as the first tag is valid.
+	ghClient := new(githubClientMock)
+
+	plan, items, err := evaluatePackage(context.Background(), ghClient, defaultPrFilterParams, CommitFilterParams{}, LabelFilterParams{}, versions, images, indices, evaluationOptions{})
+
+	// Check the result.
+	ghClient.AssertExpectations(s.T())
+
+	r := s.Require()
+	r.NoError(err)
+	r.Empty(plan.HashesToDelete())
+	r.Len(items[image1].verdicts, 1)
+}
+
+func (s *CleaningTestSuite) TestImageValidTagAllVerdicts() {
+	// Compute the hashes to delete.
+	versions, images, indices := buildTestData(map[string]TestDataItem{
+		image1: {tags: []string{"v1.2.3", "pr-1234"}, references: nil},
+	})
+
+	ghClient := new(githubClientMock)
+	ghClient.
+		On("GetPullRequestState", defaultPrFilterParams.Owner, defaultPrFilterParams.Repository, 1234).
+		Return("closed", nil)
+
+	plan, items, err := evaluatePackage(context.Background(), ghClient, defaultPrFilterParams, CommitFilterParams{}, LabelFilterParams{}, versions, images, indices, evaluationOptions{allVerdicts: true})
 
 	// Check the result.
 	ghClient.AssertExpectations(s.T())
 
 	r := s.Require()
 	r.NoError(err)
-	r.Empty(toDelete)
+	r.Empty(plan.HashesToDelete())
+	r.Len(items[image1].verdicts, 2)
+	r.Equal("obsolete", items[image1].verdicts[1].Verdict)
+}
+
+func (s *CleaningTestSuite) TestImagePullRequestTagMultipleRepositories() {
+	// Compute the hashes to delete.
+	versions, images, indices := buildTestData(map[string]TestDataItem{
+		image1: {tags: []string{"pr-frontend-123"}, references: nil},
+		image2: {tags: []string{"pr-api-45"}, references: nil},
+	})
+
+	prFilterParams := PullRequestFilterParams{
+		Owner:            "owner",
+		Repository:       "repository",
+		TagRegexes:       []*regexp.Regexp{regexp.MustCompile("^pr-(?P<repo>[a-z]+)-(?P<id>\\d+)$")},
+		RepositoryByName: map[string]string{"api": "other-owner/api-server"},
+	}
+
+	ghClient := new(githubClientMock)
+	ghClient.
+		On("GetPullRequestState", "owner", "frontend", 123).
+		Return("closed", nil).
+		On("GetPullRequestState", "other-owner", "api-server", 45).
+		Return("open", nil)
+
+	plan, err := computePlan(context.Background(), ghClient, prFilterParams, CommitFilterParams{}, LabelFilterParams{}, versions, images, indices)
+
+	// Check the result.
+	ghClient.AssertExpectations(s.T())
+
+	r := s.Require()
+	r.NoError(err)
+	r.ElementsMatch(plan.HashesToDelete(), []string{image1})
+}
+
+func (s *CleaningTestSuite) TestImagePullRequestTagMultipleRegexes() {
+	// Compute the hashes to delete.
+	versions, images, indices := buildTestData(map[string]TestDataItem{
+		image1: {tags: []string{"pr-1234"}, references: nil},
+		image2: {tags: []string{"42-1234-pr", "5678-pullrequest"}, references: nil},
+	})
+
+	prFilterParams := PullRequestFilterParams{
+		TagRegexes: []*regexp.Regexp{
+			regexp.MustCompile(DefaultPrTagPattern),
+			regexp.MustCompile("^(?P<build>\\d+)-(?P<id>\\d+)-pr$"),
+			regexp.MustCompile("^(\\d+)-pullrequest$"),
+		},
+	}
+
+	ghClient := new(githubClientMock)
+	ghClient.
+		On("GetPullRequestState", "", "", 1234).
+		Return("closed", nil).
+		On("GetPullRequestState", "", "", 5678).
+		Return("open", nil)
+
+	plan, err := computePlan(context.Background(), ghClient, prFilterParams, CommitFilterParams{}, LabelFilterParams{}, versions, images, indices)
+
+	// Check the result.
+	ghClient.AssertExpectations(s.T())
+
+	r := s.Require()
+	r.NoError(err)
+	r.ElementsMatch(plan.HashesToDelete(), []string{image1})
+}
+
+func (s *CleaningTestSuite) TestNewPrTagRegex() {
+	r := s.Require()
+
+	_, err := NewPrTagRegex(DefaultPrTagPattern)
+	r.NoError(err)
+	_, err = NewPrTagRegex("^pr-(?P<repo>[a-z]+)-(?P<id>\\d+)$")
+	r.NoError(err)
+
+	_, err = NewPrTagRegex("^pr-(\\d+")
+	r.Error(err)
+	_, err = NewPrTagRegex("^pr-\\d+$")
+	r.Error(err)
+	_, err = NewPrTagRegex("^pr-(?P<repo>[a-z]+)-\\d+$")
+	r.Error(err)
 }
 
 func (s *CleaningTestSuite) TestIndexNoTag() {
 	// Compute the hashes to delete.
-	versions, images, indices := s.buildTestData(map[string]TestDataItem{
+	versions, images, indices := buildTestData(map[string]TestDataItem{
 		image1: {tags: nil, references: nil},
 		index1: {tags: nil, references: []string{image1}},
 	})
 
-	toDelete, err := computeHashesToDelete(nil, PullRequestFilterParams{}, versions, images, indices)
+	plan, err := computePlan(context.Background(), nil, PullRequestFilterParams{}, CommitFilterParams{}, LabelFilterParams{}, versions, images, indices)
 
 	// Check the result.
 	r := s.Require()
 	r.NoError(err)
-	r.ElementsMatch(toDelete, []string{image1, index1})
+	r.ElementsMatch(plan.HashesToDelete(), []string{image1, index1})
 }
 
 func (s *CleaningTestSuite) TestIndexNoTag2() {
 	// Compute the hashes to delete.
-	versions, images, indices := s.buildTestData(map[string]TestDataItem{
+	versions, images, indices := buildTestData(map[string]TestDataItem{
 		image1: {tags: []string{"v1.2.3"}, references: nil},
 		index1: {tags: nil, references: []string{image1}},
 	})
 
-	toDelete, err := computeHashesToDelete(nil, defaultPrFilterParams, versions, images, indices)
+	plan, err := computePlan(context.Background(), nil, defaultPrFilterParams, CommitFilterParams{}, LabelFilterParams{}, versions, images, indices)
 
 	// Check the result.
 	r := s.Require()
 	r.NoError(err)
-	r.ElementsMatch(toDelete, []string{index1})
+	r.ElementsMatch(plan.HashesToDelete(), []string{index1})
 }
 
 func (s *CleaningTestSuite) TestIndexValidTag() {
 	// Compute the hashes to delete.
-	versions, images, indices := s.buildTestData(map[string]TestDataItem{
+	versions, images, indices := buildTestData(map[string]TestDataItem{
 		image1: {tags: nil, references: nil},
 		index1: {tags: []string{"v1.2.3"}, references: []string{image1}},
 	})
 
-	toDelete, err := computeHashesToDelete(nil, defaultPrFilterParams, versions, images, indices)
+	plan, err := computePlan(context.Background(), nil, defaultPrFilterParams, CommitFilterParams{}, LabelFilterParams{}, versions, images, indices)
 
 	// Check the result.
 	r := s.Require()
 	r.NoError(err)
-	r.Empty(toDelete)
+	r.Empty(plan.HashesToDelete())
 }
 
 func (s *CleaningTestSuite) TestIndexMultipleRefToImage() {
 	// Compute the hashes to delete.
-	versions, images, indices := s.buildTestData(map[string]TestDataItem{
+	versions, images, indices := buildTestData(map[string]TestDataItem{
 		image1: {tags: nil, references: nil},
 		index1: {tags: nil, references: []string{image1}},
 		index2: {tags: []string{"v1.2.3"}, references: []string{image1}},
 	})
 
-	toDelete, err := computeHashesToDelete(nil, defaultPrFilterParams, versions, images, indices)
+	plan, err := computePlan(context.Background(), nil, defaultPrFilterParams, CommitFilterParams{}, LabelFilterParams{}, versions, images, indices)
 
 	// Check the result.
 	r := s.Require()
 	r.NoError(err)
-	r.ElementsMatch(toDelete, []string{index1})
+	r.ElementsMatch(plan.HashesToDelete(), []string{index1})
 }
 
 func (s *CleaningTestSuite) TestIndexCascading() {
 	// Compute the hashes to delete.
-	versions, images, indices := s.buildTestData(map[string]TestDataItem{
+	versions, images, indices := buildTestData(map[string]TestDataItem{
 		image1: {tags: nil, references: nil},
 		index1: {tags: nil, references: []string{image1}},
 		index2: {tags: []string{"v1.2.3"}, references: []string{index1}},
 	})
 
-	toDelete, err := computeHashesToDelete(nil, defaultPrFilterParams, versions, images, indices)
+	plan, err := computePlan(context.Background(), nil, defaultPrFilterParams, CommitFilterParams{}, LabelFilterParams{}, versions, images, indices)
 
 	// Check the result.
 	r := s.Require()
 	r.NoError(err)
-	r.Empty(toDelete)
+	r.Empty(plan.HashesToDelete())
 }
 
 func (s *CleaningTestSuite) TestIndexCascading2() {
 	// Compute the hashes to delete.
-	versions, images, indices := s.buildTestData(map[string]TestDataItem{
+	versions, images, indices := buildTestData(map[string]TestDataItem{
 		image1: {tags: nil, references: nil},
 		index1: {tags: nil, references: []string{image1, index2}},
 		index2: {tags: []string{"v1.2.3"}, references: []string{image1}},
 	})
 
-	toDelete, err := computeHashesToDelete(nil, defaultPrFilterParams, versions, images, indices)
+	plan, err := computePlan(context.Background(), nil, defaultPrFilterParams, CommitFilterParams{}, LabelFilterParams{}, versions, images, indices)
 
 	// Check the result.
 	r := s.Require()
 	r.NoError(err)
-	r.ElementsMatch(toDelete, []string{index1})
+	r.ElementsMatch(plan.HashesToDelete(), []string{index1})
 }
 
 func (s *CleaningTestSuite) TestIndexCascading3() {
 	// Compute the hashes to delete.
-	versions, images, indices := s.buildTestData(map[string]TestDataItem{
+	versions, images, indices := buildTestData(map[string]TestDataItem{
 		image1: {tags: nil, references: nil},
 		index1: {tags: nil, references: []string{image1}},
 		index2: {tags: nil, references: []string{index1}},
 	})
 
-	toDelete, err := computeHashesToDelete(nil, defaultPrFilterParams, versions, images, indices)
+	plan, err := computePlan(context.Background(), nil, defaultPrFilterParams, CommitFilterParams{}, LabelFilterParams{}, versions, images, indices)
 
 	// Check the result.
 	r := s.Require()
 	r.NoError(err)
-	r.ElementsMatch(toDelete, []string{image1, index1, index2})
+	r.ElementsMatch(plan.HashesToDelete(), []string{image1, index1, index2})
 }
 
 func (s *CleaningTestSuite) TestIndexCascading4() {
 	// Compute the hashes to delete.
-	versions, images, indices := s.buildTestData(map[string]TestDataItem{
+	versions, images, indices := buildTestData(map[string]TestDataItem{
 		image1: {tags: nil, references: nil},
 		index1: {tags: []string{"pr-1234"}, references: []string{image1}},
 		index2: {tags: []string{"v1.2.3"}, references: []string{index1}},
@@ -340,27 +565,635 @@ func (s *CleaningTestSuite) TestIndexCascading4() {
 		On("GetPullRequestState", defaultPrFilterParams.Owner, defaultPrFilterParams.Repository, 1234).
 		Return("closed", nil)
 
-	toDelete, err := computeHashesToDelete(ghClient, defaultPrFilterParams, versions, images, indices)
+	plan, err := computePlan(context.Background(), ghClient, defaultPrFilterParams, CommitFilterParams{}, LabelFilterParams{}, versions, images, indices)
 
 	// Check the result.
 	r := s.Require()
 	r.NoError(err)
-	r.Empty(toDelete)
+	r.Empty(plan.HashesToDelete())
 }
 
 func (s *CleaningTestSuite) TestIndexMultipleReferences() {
 	// Compute the hashes to delete.
-	versions, images, indices := s.buildTestData(map[string]TestDataItem{
+	versions, images, indices := buildTestData(map[string]TestDataItem{
+		image1: {tags: nil, references: nil},
+		index1: {tags: nil, references: []string{image1, image1}},
+	})
+
+	plan, err := computePlan(context.Background(), nil, defaultPrFilterParams, CommitFilterParams{}, LabelFilterParams{}, versions, images, indices)
+
+	// Check the result.
+	r := s.Require()
+	r.NoError(err)
+	r.ElementsMatch(plan.HashesToDelete(), []string{image1, index1})
+}
+
+func (s *CleaningTestSuite) TestIndexMultipleReferencesEdges() {
+	versions, images, indices := buildTestData(map[string]TestDataItem{
 		image1: {tags: nil, references: nil},
 		index1: {tags: nil, references: []string{image1, image1}},
 	})
 
-	toDelete, err := computeHashesToDelete(nil, defaultPrFilterParams, versions, images, indices)
+	plan, items, err := evaluatePackage(context.Background(), nil, defaultPrFilterParams, CommitFilterParams{}, LabelFilterParams{}, versions, images, indices, evaluationOptions{})
+
+	// Check the result, the index has a single edge to the image.
+	r := s.Require()
+	r.NoError(err)
+	r.Equal([]string{index1}, items[image1].parents)
+	r.Len(items[index1].references, 1)
+
+	for _, decision := range plan.Decisions {
+		if decision.Hash == image1 {
+			r.Equal("deleted because its only parent "+index1+" was deleted", decision.Provenance)
+		}
+		if decision.Hash == index1 {
+			r.Equal([]string{image1}, decision.Manifests)
+		}
+	}
+}
+
+func (s *CleaningTestSuite) TestImageReachableCommitTag() {
+	// Compute the hashes to delete.
+	versions, images, indices := buildTestData(map[string]TestDataItem{
+		image1: {tags: []string{"sha-1234abc"}, references: nil},
+	})
+
+	ghClient := new(githubClientMock)
+	ghClient.
+		On("GetAllBranches", defaultCommitFilterParams.Owner, defaultCommitFilterParams.Repository).
+		Return([]*github.Branch{{Name: github.String("main")}, {Name: github.String("feature")}}, nil).
+		On("GetCommitComparisonStatus", defaultCommitFilterParams.Owner, defaultCommitFilterParams.Repository, "main", "1234abc").
+		Return("behind", nil)
+
+	plan, err := computePlan(context.Background(), ghClient, defaultPrFilterParams, defaultCommitFilterParams, LabelFilterParams{}, versions, images, indices)
 
 	// Check the result.
+	ghClient.AssertExpectations(s.T())
+
 	r := s.Require()
 	r.NoError(err)
-	r.ElementsMatch(toDelete, []string{image1, index1})
+	r.Empty(plan.HashesToDelete())
+}
+
+func (s *CleaningTestSuite) TestImageUnreachableCommitTag() {
+	// Compute the hashes to delete.
+	versions, images, indices := buildTestData(map[string]TestDataItem{
+		image1: {tags: []string{"sha-1234abc"}, references: nil},
+		image2: {tags: []string{"sha-1234abc", "pr-1234"}, references: nil},
+	})
+
+	ghClient := new(githubClientMock)
+	ghClient.
+		On("GetAllBranches", defaultCommitFilterParams.Owner, defaultCommitFilterParams.Repository).
+		Return([]*github.Branch{{Name: github.String("main")}}, nil).
+		Once().
+		On("GetCommitComparisonStatus", defaultCommitFilterParams.Owner, defaultCommitFilterParams.Repository, "main", "1234abc").
+		Return("diverged", nil).
+		Once().
+		On("GetPullRequestState", defaultPrFilterParams.Owner, defaultPrFilterParams.Repository, 1234).
+		Return("closed", nil)
+
+	plan, err := computePlan(context.Background(), ghClient, defaultPrFilterParams, defaultCommitFilterParams, LabelFilterParams{}, versions, images, indices)
+
+	// Check the result.
+	ghClient.AssertExpectations(s.T())
+
+	r := s.Require()
+	r.NoError(err)
+	r.ElementsMatch(plan.HashesToDelete(), []string{image1, image2})
+}
+
+func (s *CleaningTestSuite) TestImageCommitTagNoProtectedRef() {
+	// Compute the hashes to delete.
+	versions, images, indices := buildTestData(map[string]TestDataItem{
+		image1: {tags: []string{"sha-1234abc"}, references: nil},
+	})
+
+	ghClient := new(githubClientMock)
+	ghClient.
+		On("GetAllBranches", defaultCommitFilterParams.Owner, defaultCommitFilterParams.Repository).
+		Return([]*github.Branch{{Name: github.String("feature")}}, nil)
+
+	plan, err := computePlan(context.Background(), ghClient, defaultPrFilterParams, defaultCommitFilterParams, LabelFilterParams{}, versions, images, indices)
+
+	// Check the result.
+	ghClient.AssertExpectations(s.T())
+
+	r := s.Require()
+	r.NoError(err)
+	r.Empty(plan.HashesToDelete())
+}
+
+func (s *CleaningTestSuite) TestImageNoTagOpenPullRequestRevision() {
+	// Compute the hashes to delete.
+	versions, images, indices := buildTestData(map[string]TestDataItem{
+		image1: {tags: nil, references: nil, labels: map[string]string{RevisionLabel: "1234abc"}},
+		image2: {tags: nil, references: nil, labels: nil},
+	})
+
+	ghClient := new(githubClientMock)
+	ghClient.
+		On("GetAllPullRequestsForCommit", defaultPrFilterParams.Owner, defaultPrFilterParams.Repository, "1234abc").
+		Return([]*github.PullRequest{{State: github.String("closed")}, {State: github.String("open")}}, nil)
+
+	plan, err := computePlan(context.Background(), ghClient, defaultPrFilterParams, defaultCommitFilterParams, labelLookupFilterParams, versions, images, indices)
+
+	// Check the result: the untagged image is kept by its revision, but not without the label lookup.
+	ghClient.AssertExpectations(s.T())
+
+	r := s.Require()
+	r.NoError(err)
+	r.ElementsMatch(plan.HashesToDelete(), []string{image2})
+
+	plan, err = computePlan(context.Background(), ghClient, defaultPrFilterParams, defaultCommitFilterParams, LabelFilterParams{}, versions, images, indices)
+	r.NoError(err)
+	r.ElementsMatch(plan.HashesToDelete(), []string{image1, image2})
+}
+
+func (s *CleaningTestSuite) TestImageNoTagClosedPullRequestRevision() {
+	// Compute the hashes to delete.
+	versions, images, indices := buildTestData(map[string]TestDataItem{
+		image1: {tags: nil, references: nil, labels: map[string]string{RevisionLabel: "1234abc"}},
+	})
+
+	// The revision is reachable from the main branch once merged, but its pull request is closed.
+	ghClient := new(githubClientMock)
+	ghClient.
+		On("GetAllPullRequestsForCommit", defaultPrFilterParams.Owner, defaultPrFilterParams.Repository, "1234abc").
+		Return([]*github.PullRequest{{State: github.String("closed")}}, nil)
+
+	plan, err := computePlan(context.Background(), ghClient, defaultPrFilterParams, defaultCommitFilterParams, labelLookupFilterParams, versions, images, indices)
+
+	// Check the result.
+	ghClient.AssertExpectations(s.T())
+
+	r := s.Require()
+	r.NoError(err)
+	r.Equal([]string{image1}, plan.HashesToDelete())
+	r.Equal("no tags, obsolete revision '1234abc'", plan.Decisions[0].Reason)
+}
+
+func (s *CleaningTestSuite) TestImageNoTagReachableRevision() {
+	// Compute the hashes to delete.
+	versions, images, indices := buildTestData(map[string]TestDataItem{
+		image1: {tags: nil, references: nil, labels: map[string]string{RevisionLabel: "1234abc"}},
+		image2: {tags: nil, references: nil, labels: map[string]string{RevisionLabel: "5678def"}},
+	})
+
+	// The revisions were pushed without any pull request.
+	ghClient := new(githubClientMock)
+	ghClient.
+		On("GetAllPullRequestsForCommit", defaultPrFilterParams.Owner, defaultPrFilterParams.Repository, "1234abc").
+		Return([]*github.PullRequest{}, nil).
+		On("GetAllPullRequestsForCommit", defaultPrFilterParams.Owner, defaultPrFilterParams.Repository, "5678def").
+		Return([]*github.PullRequest{}, nil).
+		On("GetAllBranches", defaultCommitFilterParams.Owner, defaultCommitFilterParams.Repository).
+		Return([]*github.Branch{{Name: github.String("main")}}, nil).
+		Once().
+		On("GetCommitComparisonStatus", defaultCommitFilterParams.Owner, defaultCommitFilterParams.Repository, "main", "1234abc").
+		Return("behind", nil).
+		On("GetCommitComparisonStatus", defaultCommitFilterParams.Owner, defaultCommitFilterParams.Repository, "main", "5678def").
+		Return("diverged", nil)
+
+	plan, err := computePlan(context.Background(), ghClient, defaultPrFilterParams, defaultCommitFilterParams, labelLookupFilterParams, versions, images, indices)
+
+	// Check the result.
+	ghClient.AssertExpectations(s.T())
+
+	r := s.Require()
+	r.NoError(err)
+	r.Equal([]string{image2}, plan.HashesToDelete())
+}
+
+func (s *CleaningTestSuite) TestImageNoTagRevisionSource() {
+	// Compute the hashes to delete.
+	versions, images, indices := buildTestData(map[string]TestDataItem{
+		image1: {tags: nil, references: nil, labels: map[string]string{
+			RevisionLabel: "1234abc",
+			SourceLabel:   "https://github.com/other-owner/other-repository.git",
+		}},
+		image2: {tags: nil, references: nil, labels: map[string]string{
+			RevisionLabel: "5678def",
+			SourceLabel:   "invalid",
+		}},
+	})
+
+	// The revision is looked up in the source repository, whose reachability is unknown.
+	ghClient := new(githubClientMock)
+	ghClient.
+		On("GetAllPullRequestsForCommit", "other-owner", "other-repository", "1234abc").
+		Return([]*github.PullRequest{{State: github.String("open")}}, nil)
+
+	plan, err := computePlan(context.Background(), ghClient, defaultPrFilterParams, defaultCommitFilterParams, labelLookupFilterParams, versions, images, indices)
+
+	// Check the result.
+	ghClient.AssertExpectations(s.T())
+
+	r := s.Require()
+	r.NoError(err)
+	r.Equal([]string{image2}, plan.HashesToDelete())
+}
+
+func (s *CleaningTestSuite) TestImageNoTagRevisionWithParent() {
+	// Compute the hashes to delete.
+	versions, images, indices := buildTestData(map[string]TestDataItem{
+		image1: {tags: nil, references: nil, labels: map[string]string{RevisionLabel: "1234abc"}},
+		index1: {tags: nil, references: []string{image1}},
+	})
+
+	// The revision of an image having a parent is not checked, the image follows its parent.
+	ghClient := new(githubClientMock)
+
+	plan, err := computePlan(context.Background(), ghClient, defaultPrFilterParams, defaultCommitFilterParams, labelLookupFilterParams, versions, images, indices)
+
+	// Check the result.
+	ghClient.AssertExpectations(s.T())
+
+	r := s.Require()
+	r.NoError(err)
+	r.ElementsMatch([]string{image1, index1}, plan.HashesToDelete())
+}
+
+func (s *CleaningTestSuite) TestImageOtherTagObsoleteRevision() {
+	// Compute the hashes to delete.
+	versions, images, indices := buildTestData(map[string]TestDataItem{
+		image1: {tags: []string{"v1.2.3"}, references: nil, labels: map[string]string{RevisionLabel: "1234abc"}},
+		image2: {tags: []string{"latest"}, references: nil, labels: map[string]string{RevisionLabel: "5678def"}},
+	})
+
+	// The revisions are not checked, a tag related neither to a pull request nor to a commit is always valid.
+	ghClient := new(githubClientMock)
+
+	plan, err := computePlan(context.Background(), ghClient, defaultPrFilterParams, defaultCommitFilterParams, labelLookupFilterParams, versions, images, indices)
+
+	// Check the result.
+	ghClient.AssertExpectations(s.T())
+
+	r := s.Require()
+	r.NoError(err)
+	r.Empty(plan.HashesToDelete())
+}
+
+func (s *CleaningTestSuite) TestImagePullRequestTagOpenPullRequestRevision() {
+	// Compute the hashes to delete.
+	versions, images, indices := buildTestData(map[string]TestDataItem{
+		image1: {tags: []string{"pr-1"}, references: nil, labels: map[string]string{
+			RevisionLabel: "1234abc",
+			SourceLabel:   "https://github.com/owner/repository.git",
+		}},
+		image2: {tags: []string{"pr-2"}, references: nil, labels: map[string]string{
+			RevisionLabel: "1234abc",
+			SourceLabel:   "git@github.com:owner/other.git",
+		}},
+	})
+
+	prFilterParams := defaultPrFilterParams
+	prFilterParams.Owner = "owner"
+	prFilterParams.Repository = "repository"
+
+	ghClient := new(githubClientMock)
+	ghClient.
+		On("GetPullRequestState", "owner", "repository", 1).
+		Return("closed", nil).
+		On("GetPullRequestState", "owner", "repository", 2).
+		Return("closed", nil).
+		On("GetAllPullRequestsForCommit", "owner", "repository", "1234abc").
+		Return([]*github.PullRequest{{State: github.String("closed")}, {State: github.String("open")}}, nil).
+		Once().
+		On("GetAllPullRequestsForCommit", "owner", "other", "1234abc").
+		Return([]*github.PullRequest{{State: github.String("closed")}}, nil).
+		Once()
+
+	plan, err := computePlan(context.Background(), ghClient, prFilterParams, CommitFilterParams{}, labelLookupFilterParams, versions, images, indices)
+
+	// Check the result: the revision is looked up in the source repository.
+	ghClient.AssertExpectations(s.T())
+
+	r := s.Require()
+	r.NoError(err)
+	r.ElementsMatch(plan.HashesToDelete(), []string{image2})
+}
+
+func (s *CleaningTestSuite) TestImageCommitTagOpenPullRequestRevision() {
+	// Compute the hashes to delete.
+	versions, images, indices := buildTestData(map[string]TestDataItem{
+		image1: {tags: []string{"sha-1234abc"}, references: nil, labels: map[string]string{RevisionLabel: "1234abc"}},
+		image2: {tags: []string{"sha-5678def"}, references: nil, labels: map[string]string{RevisionLabel: "5678def"}},
+	})
+
+	ghClient := new(githubClientMock)
+	ghClient.
+		On("GetAllBranches", defaultCommitFilterParams.Owner, defaultCommitFilterParams.Repository).
+		Return([]*github.Branch{{Name: github.String("main")}}, nil).
+		On("GetCommitComparisonStatus", defaultCommitFilterParams.Owner, defaultCommitFilterParams.Repository, "main", "1234abc").
+		Return("diverged", nil).
+		On("GetCommitComparisonStatus", defaultCommitFilterParams.Owner, defaultCommitFilterParams.Repository, "main", "5678def").
+		Return("identical", nil).
+		On("GetAllPullRequestsForCommit", defaultPrFilterParams.Owner, defaultPrFilterParams.Repository, "1234abc").
+		Return([]*github.PullRequest{{State: github.String("open")}}, nil)
+
+	plan, err := computePlan(context.Background(), ghClient, defaultPrFilterParams, defaultCommitFilterParams, labelLookupFilterParams, versions, images, indices)
+
+	// Check the result: the revision of a reachable commit is not checked.
+	ghClient.AssertExpectations(s.T())
+
+	r := s.Require()
+	r.NoError(err)
+	r.Empty(plan.HashesToDelete())
+}
+
+func (s *CleaningTestSuite) TestImageKeepLabel() {
+	// Compute the hashes to delete.
+	versions, images, indices := buildTestData(map[string]TestDataItem{
+		image1: {tags: nil, references: nil, labels: map[string]string{KeepMarker: "true"}},
+		image2: {tags: nil, references: nil, labels: map[string]string{KeepMarker: "false"}},
+	})
+
+	plan, err := computePlan(context.Background(), nil, defaultPrFilterParams, CommitFilterParams{}, LabelFilterParams{}, versions, images, indices)
+
+	// Check the result.
+	r := s.Require()
+	r.NoError(err)
+	r.ElementsMatch(plan.HashesToDelete(), []string{image2})
+	r.Equal(plan.Decisions[0].Hash, image1)
+	r.Equal(plan.Decisions[0].Reason, "protected by label 'ghcr-cleaning.keep=true'")
+}
+
+func (s *CleaningTestSuite) TestImageExpiresLabel() {
+	// Compute the hashes to delete.
+	versions, images, indices := buildTestData(map[string]TestDataItem{
+		image1: {tags: nil, references: nil, labels: map[string]string{ExpiresMarker: "2999-12-31"}},
+		image2: {tags: nil, references: nil, labels: map[string]string{ExpiresMarker: "2000-01-01"}},
+	})
+
+	plan, err := computePlan(context.Background(), nil, defaultPrFilterParams, CommitFilterParams{}, LabelFilterParams{}, versions, images, indices)
+
+	// Check the result.
+	r := s.Require()
+	r.NoError(err)
+	r.ElementsMatch(plan.HashesToDelete(), []string{image2})
+}
+
+func (s *CleaningTestSuite) TestIndexKeepAnnotation() {
+	// Compute the hashes to delete.
+	versions, images, indices := buildTestData(map[string]TestDataItem{
+		image1: {tags: nil, references: nil},
+		index1: {tags: nil, references: []string{image1}, labels: map[string]string{ExpiresMarker: "2999-12-31"}},
+	})
+
+	plan, err := computePlan(context.Background(), nil, defaultPrFilterParams, CommitFilterParams{}, LabelFilterParams{}, versions, images, indices)
+
+	// Check the result.
+	r := s.Require()
+	r.NoError(err)
+	r.Empty(plan.HashesToDelete())
+}
+
+func (s *CleaningTestSuite) TestGetKeepReason() {
+	now := time.Date(2026, 12, 31, 12, 0, 0, 0, time.UTC)
+
+	r := s.Require()
+	r.Empty(getKeepReason(nil, "label", now))
+	r.Empty(getKeepReason(map[string]string{KeepMarker: "false"}, "label", now))
+	r.Equal(getKeepReason(map[string]string{KeepMarker: "true"}, "label", now), "protected by label 'ghcr-cleaning.keep=true'")
+	r.Equal(getKeepReason(map[string]string{KeepMarker: "yes"}, "label", now), "invalid label 'ghcr-cleaning.keep=yes'")
+	r.Equal(getKeepReason(map[string]string{ExpiresMarker: "2026-12-31"}, "annotation", now), "protected by annotation 'ghcr-cleaning.expires=2026-12-31'")
+	r.Empty(getKeepReason(map[string]string{ExpiresMarker: "2026-12-30"}, "annotation", now))
+	r.Equal(getKeepReason(map[string]string{ExpiresMarker: "31/12/2026"}, "annotation", now), "invalid annotation 'ghcr-cleaning.expires=31/12/2026'")
+}
+
+func (s *CleaningTestSuite) TestReferrerKeptWithSubject() {
+	// The untagged image 2 refers to the image index.
+	for _, indexTags := range [][]string{{"v1.2.3"}, nil} {
+		versions, images, indices := buildTestData(map[string]TestDataItem{
+			image1: {tags: nil, references: nil},
+			image2: {tags: nil, references: nil},
+			index1: {tags: indexTags, references: []string{image1}},
+		})
+		subject, _ := v1.NewHash(index1)
+		images[image2] = &fake.FakeImage{
+			ManifestStub: func() (*v1.Manifest, error) {
+				return &v1.Manifest{Subject: &v1.Descriptor{Digest: subject}}, nil
+			},
+		}
+
+		plan, err := computePlan(context.Background(), nil, defaultPrFilterParams, CommitFilterParams{}, LabelFilterParams{}, versions, images, indices)
+
+		// Check the result, the referrer follows its subject.
+		r := s.Require()
+		r.NoError(err)
+		if indexTags != nil {
+			r.Empty(plan.HashesToDelete())
+			for _, decision := range plan.Decisions {
+				if decision.Hash == image2 {
+					r.Equal("referrer of a kept object", decision.Reason)
+				}
+			}
+		} else {
+			// The referrer is deleted before its subject.
+			r.Equal([]string{image2, index1, image1}, plan.HashesToDelete())
+		}
+	}
+}
+
+func (s *CleaningTestSuite) TestDecisionProvenance() {
+	for _, index2Tags := range [][]string{{"v1.2.3"}, nil} {
+		versions, images, indices := buildTestData(map[string]TestDataItem{
+			image1: {tags: nil, references: nil},
+			image2: {tags: nil, references: nil},
+			index1: {tags: nil, references: []string{image1, image2}},
+			index2: {tags: index2Tags, references: []string{image1}},
+		})
+
+		plan, err := computePlan(context.Background(), nil, defaultPrFilterParams, CommitFilterParams{}, LabelFilterParams{}, versions, images, indices)
+
+		// Check the result.
+		r := s.Require()
+		r.NoError(err)
+
+		provenanceByHash := make(map[string]string)
+		for _, decision := range plan.Decisions {
+			provenanceByHash[decision.Hash] = decision.Provenance
+		}
+		r.Empty(provenanceByHash[index1])
+		r.Empty(provenanceByHash[index2])
+		r.Equal("deleted because its only parent "+index1+" was deleted", provenanceByHash[image2])
+		if index2Tags != nil {
+			r.Equal("kept because its parent "+index2+" is kept", provenanceByHash[image1])
+		} else {
+			r.Equal("deleted because all its parents were deleted: "+index1+", "+index2, provenanceByHash[image1])
+		}
+	}
+}
+
+func (s *CleaningTestSuite) TestComputePlanLongChain() {
+	// Create a chain of image indices, each one referencing the next one.
+	items := make(map[string]TestDataItem)
+	const length = 20000
+	for i := 0; i < length; i++ {
+		item := TestDataItem{}
+		if i < length-1 {
+			item.references = []string{fmt.Sprintf("sha256:%064x", i+1)}
+		}
+		items[fmt.Sprintf("sha256:%064x", i)] = item
+	}
+	versions, images, indices := buildTestData(items)
+
+	plan, err := computePlan(context.Background(), nil, defaultPrFilterParams, CommitFilterParams{}, LabelFilterParams{}, versions, images, indices)
+
+	// Check the result, the whole chain is deleted from its head.
+	r := s.Require()
+	r.NoError(err)
+	r.Len(plan.HashesToDelete(), length)
+	r.Equal(fmt.Sprintf("sha256:%064x", 0), plan.Decisions[0].Hash)
+}
+
+func (s *CleaningTestSuite) TestDeletionOrder() {
+	versions, images, indices := buildTestData(map[string]TestDataItem{
+		image1: {tags: nil, references: nil},
+		image2: {tags: nil, references: nil},
+		index1: {tags: nil, references: []string{image1, image2}},
+		index2: {tags: nil, references: []string{image1}},
+	})
+
+	// Compute the plan several times, as the maps iteration order is random.
+	for i := 0; i < 10; i++ {
+		plan, err := computePlan(context.Background(), nil, defaultPrFilterParams, CommitFilterParams{}, LabelFilterParams{}, versions, images, indices)
+
+		// Check the result, the indices are deleted before the images they reference, the ties are broken by hash.
+		r := s.Require()
+		r.NoError(err)
+		r.Equal([]string{index1, index2, image1, image2}, plan.HashesToDelete())
+	}
+}
+
+func (s *CleaningTestSuite) TestComputePlanCancelled() {
+	versions, images, indices := buildTestData(map[string]TestDataItem{
+		image1: {tags: nil, references: nil},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	plan, err := computePlan(ctx, nil, PullRequestFilterParams{}, CommitFilterParams{}, LabelFilterParams{}, versions, images, indices)
+
+	r := s.Require()
+	r.ErrorIs(err, context.Canceled)
+	r.Nil(plan)
+}
+
+func (s *CleaningTestSuite) TestCleanInterrupted() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ghClient, regClient := s.newCleanMocks()
+	ghClient.
+		On("DeleteContainerPackageVersion", "user", "package", int64(1)).
+		Run(func(args mock.Arguments) {
+			// Interrupt the run after the first deletion.
+			cancel()
+		}).
+		Return(nil)
+
+	plan, err := Clean(ctx, ghClient, PullRequestFilterParams{}, CommitFilterParams{}, LabelFilterParams{}, regClient, cleanPkgRegistryParams, false)
+
+	// Check the result, the second version must not have been deleted.
+	ghClient.AssertExpectations(s.T())
+	ghClient.AssertNotCalled(s.T(), "DeleteContainerPackageVersion", "user", "package", int64(2))
+
+	r := s.Require()
+	r.ErrorIs(err, context.Canceled)
+	r.NotNil(plan)
+	deleted, notDeleted := plan.DeletionStatus()
+	r.Equal([]string{image1}, deleted)
+	r.Equal([]string{image2}, notDeleted)
+}
+
+func (s *CleaningTestSuite) TestCleanDeletionStrategies() {
+	testCases := []struct {
+		strategy  string
+		deletedBy []string
+	}{
+		{strategy: "", deletedBy: []string{GithubDeletion, GithubDeletion}},
+		{strategy: GithubDeletion, deletedBy: []string{GithubDeletion, GithubDeletion}},
+		{strategy: RegistryDeletion, deletedBy: []string{RegistryDeletion, RegistryDeletion}},
+		{strategy: BothDeletion, deletedBy: []string{GithubDeletion, RegistryDeletion}},
+	}
+
+	for _, testCase := range testCases {
+		ghClient, regClient := s.newCleanMocks()
+		ghClient.
+			On("DeleteContainerPackageVersion", "user", "package", int64(1)).
+			Return(nil).
+			On("DeleteContainerPackageVersion", "user", "package", int64(2)).
+			Return(errors.New("not supported"))
+		regClient.
+			On("DeleteRegistryObject", cleanRepository, mock.Anything).
+			Return(nil)
+
+		pkgRegistryParams := cleanPkgRegistryParams
+		pkgRegistryParams.DeletionStrategy = testCase.strategy
+		plan, err := Clean(context.Background(), ghClient, PullRequestFilterParams{}, CommitFilterParams{}, LabelFilterParams{}, regClient, pkgRegistryParams, false)
+
+		// Check the result.
+		r := s.Require()
+		if testCase.strategy == "" || testCase.strategy == GithubDeletion {
+			// The second version cannot be deleted.
+			r.Error(err)
+			r.True(plan.Decisions[0].Deleted)
+			r.Equal(testCase.deletedBy[0], plan.Decisions[0].DeletedBy)
+			r.False(plan.Decisions[1].Deleted)
+			r.Empty(plan.Decisions[1].DeletedBy)
+			regClient.AssertNotCalled(s.T(), "DeleteRegistryObject", cleanRepository, mock.Anything)
+			continue
+		}
+
+		r.NoError(err, testCase.strategy)
+		for i, decision := range plan.Decisions {
+			r.True(decision.Deleted)
+			r.Equal(testCase.deletedBy[i], decision.DeletedBy, testCase.strategy)
+		}
+	}
+}
+
+func (s *CleaningTestSuite) TestCleanRegistryBackendStrategy() {
+	pkgRegistryParams := cleanPkgRegistryParams
+	pkgRegistryParams.Backend = RegistryBackend
+	pkgRegistryParams.DeletionStrategy = BothDeletion
+
+	_, err := Clean(context.Background(), nil, PullRequestFilterParams{}, CommitFilterParams{}, LabelFilterParams{}, nil, pkgRegistryParams, false)
+	s.Require().ErrorContains(err, "not supported by the registry backend")
+}
+
+func (s *CleaningTestSuite) TestGetRepository() {
+	for _, testCase := range []struct {
+		params     PackageRegistryParams
+		repository string
+	}{
+		{PackageRegistryParams{Registry: "ghcr.io", User: "user", PackageName: "package"}, "ghcr.io/user/package"},
+		{PackageRegistryParams{Registry: "ghcr.io", User: "MyOrg", PackageName: "Package"}, "ghcr.io/myorg/package"},
+		{PackageRegistryParams{Registry: "https://containers.github.example.com/", User: "user", PackageName: "package"}, "containers.github.example.com/user/package"},
+	} {
+		s.Require().Equal(testCase.repository, testCase.params.getRepository())
+	}
+}
+
+func (s *CleaningTestSuite) TestGetContainerRegistryHost() {
+	for _, testCase := range []struct {
+		apiURL string
+		host   string
+	}{
+		{"", "ghcr.io"},
+		{"https://api.github.com", "ghcr.io"},
+		{"https://github.example.com/api/v3", "containers.github.example.com"},
+		{"https://api.octocorp.ghe.com", "containers.octocorp.ghe.com"},
+	} {
+		host, err := GetContainerRegistryHost(testCase.apiURL)
+		s.Require().NoError(err)
+		s.Require().Equal(testCase.host, host)
+	}
+
+	_, err := GetContainerRegistryHost("api/v3")
+	s.Require().Error(err)
 }
 
 //
@@ -373,9 +1206,12 @@ type TestDataItem struct {
 
 	// If `references` is not empty, item is considered to be an index, otherwise it is an image.
 	references []string
+
+	// The labels of the image configuration, or the annotations of the index manifest.
+	labels map[string]string
 }
 
-func (s *CleaningTestSuite) buildTestData(items map[string]TestDataItem) (
+func buildTestData(items map[string]TestDataItem) (
 	map[string]*github.PackageVersion,
 	map[string]v1.Image,
 	map[string]v1.ImageIndex,
@@ -396,7 +1232,16 @@ func (s *CleaningTestSuite) buildTestData(items map[string]TestDataItem) (
 	imageByHash := make(map[string]v1.Image)
 	for hash, item := range items {
 		if len(item.references) == 0 {
-			imageByHash[hash] = &fake.FakeImage{}
+			labels := item.labels
+			imageByHash[hash] = &fake.FakeImage{
+				ConfigFileStub: func() (*v1.ConfigFile, error) {
+					return &v1.ConfigFile{
+						Config: v1.Config{
+							Labels: labels,
+						},
+					}, nil
+				},
+			}
 		}
 	}
 
@@ -414,10 +1259,12 @@ func (s *CleaningTestSuite) buildTestData(items map[string]TestDataItem) (
 			}
 
 			// Create the image index.
+			annotations := item.labels
 			indexByHash[hash] = &fake.FakeImageIndex{
 				IndexManifestStub: func() (*v1.IndexManifest, error) {
 					return &v1.IndexManifest{
-						Manifests: manifests,
+						Manifests:   manifests,
+						Annotations: annotations,
 					}, nil
 				},
 			}
@@ -427,8 +1274,40 @@ func (s *CleaningTestSuite) buildTestData(items map[string]TestDataItem) (
 	return packageVersionByHash, imageByHash, indexByHash
 }
 
+// newCleanMocks returns the client mocks for the cleaning of a package made of the untagged images 1 and 2, whose
+// version identifiers are respectively 1 and 2.
+func (s *CleaningTestSuite) newCleanMocks() (*githubClientMock, *registryClientMock) {
+	versions, images, _ := buildTestData(map[string]TestDataItem{
+		image1: {tags: nil, references: nil},
+		image2: {tags: nil, references: nil},
+	})
+
+	ghClient := new(githubClientMock)
+	ghClient.
+		On("GetTokenScopes").
+		Return(TokenScopes{}, nil).
+		On("GetContainerPackage", "user", "package").
+		Return(&github.Package{}, nil).
+		On("GetLatestContainerPackageVersion", "user", "package").
+		Return(&github.PackageVersion{}, nil).
+		On("GetAllContainerPackageVersions", "user", "package").
+		Return([]*github.PackageVersion{
+			{ID: github.Int64(1), Name: github.String(image1), Metadata: versions[image1].Metadata},
+			{ID: github.Int64(2), Name: github.String(image2), Metadata: versions[image2].Metadata},
+		}, nil)
+
+	regClient := new(registryClientMock)
+	regClient.
+		On("GetRegistryObjectFromHash", cleanRepository, image1).
+		Return(images[image1], nil, nil).
+		On("GetRegistryObjectFromHash", cleanRepository, image2).
+		Return(images[image2], nil, nil)
+
+	return ghClient, regClient
+}
+
 func (s *CleaningTestSuite) TestBuildTestData() {
-	versions, images, indices := s.buildTestData(map[string]TestDataItem{
+	versions, images, indices := buildTestData(map[string]TestDataItem{
 		image1: {tags: nil, references: nil},
 		image2: {tags: []string{"tag1", "tag2"}, references: nil},
 		index1: {tags: nil, references: []string{image1, image2}},
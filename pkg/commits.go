@@ -0,0 +1,133 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"regexp"
+)
+
+const DefaultProtectedBranchPattern = "^main$"
+
+type CommitFilterParams struct {
+	Owner                string
+	Repository           string
+	TagRegex             *regexp.Regexp
+	ProtectedBranchRegex *regexp.Regexp
+	ProtectedTagRegex    *regexp.Regexp
+}
+
+// NewCommitTagRegex compiles and validates a commit tag regular expression, it must include one capture group for the
+// commit SHA.
+func NewCommitTagRegex(pattern string) (*regexp.Regexp, error) {
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid commit tag regex '%s': %w", pattern, err)
+	}
+
+	if regex.NumSubexp() < 1 {
+		return nil, fmt.Errorf("invalid commit tag regex '%s': a capture group is required for the commit SHA", pattern)
+	}
+
+	return regex, nil
+}
+
+// commitChecker checks whether commits are reachable from the protected references of a repository.
+// The protected references are resolved on first use and the result is cached per commit.
+type commitChecker struct {
+	ghClient          GithubClient
+	params            CommitFilterParams
+	protectedRefs     []string
+	reachableByCommit map[string]bool
+}
+
+func newCommitChecker(ghClient GithubClient, params CommitFilterParams) *commitChecker {
+	return &commitChecker{
+		ghClient:          ghClient,
+		params:            params,
+		protectedRefs:     nil,
+		reachableByCommit: make(map[string]bool),
+	}
+}
+
+// isCommitReachable returns whether a commit is reachable from at least one of the protected references.
+func (c *commitChecker) isCommitReachable(ctx context.Context, sha string) (bool, error) {
+	// Check if the commit has already been checked.
+	if reachable, found := c.reachableByCommit[sha]; found {
+		return reachable, nil
+	}
+
+	// Get the protected references.
+	refs, err := c.getProtectedRefs(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	// The commit is reachable from a reference if it is behind or identical to it.
+	reachable := false
+	for _, ref := range refs {
+		status, err := c.ghClient.GetCommitComparisonStatus(ctx, c.params.Owner, c.params.Repository, ref, sha)
+		if err != nil {
+			return false, fmt.Errorf("unable to compare commit '%s' to reference '%s': %w", sha, ref, err)
+		}
+
+		if status == "behind" || status == "identical" {
+			reachable = true
+			break
+		}
+	}
+
+	log.Trace().Str("commit", sha).Bool("reachable", reachable).Msg("commit reachability checked")
+	c.reachableByCommit[sha] = reachable
+
+	return reachable, nil
+}
+
+// getProtectedRefs returns the names of the branches and tags matching the protected references patterns.
+func (c *commitChecker) getProtectedRefs(ctx context.Context) ([]string, error) {
+	if c.protectedRefs != nil {
+		return c.protectedRefs, nil
+	}
+
+	var refs []string
+
+	// Add the protected branches.
+	if c.params.ProtectedBranchRegex != nil {
+		branches, err := c.ghClient.GetAllBranches(ctx, c.params.Owner, c.params.Repository)
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve the branches: %w", err)
+		}
+
+		for _, branch := range branches {
+			if c.params.ProtectedBranchRegex.MatchString(branch.GetName()) {
+				refs = append(refs, branch.GetName())
+			}
+		}
+	}
+
+	// Add the protected tags.
+	if c.params.ProtectedTagRegex != nil {
+		tags, err := c.ghClient.GetAllTags(ctx, c.params.Owner, c.params.Repository)
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve the tags: %w", err)
+		}
+
+		for _, tag := range tags {
+			if c.params.ProtectedTagRegex.MatchString(tag.GetName()) {
+				refs = append(refs, tag.GetName())
+			}
+		}
+	}
+
+	// Without any protected reference all the commits would be considered unreachable, which is most likely a
+	// misconfiguration.
+	if len(refs) == 0 {
+		return nil, errors.New("no protected reference found")
+	}
+
+	log.Debug().Strs("refs", refs).Msg("protected references resolved")
+	c.protectedRefs = refs
+
+	return refs, nil
+}
@@ -0,0 +1,120 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-github/v49/github"
+	"github.com/rs/zerolog/log"
+	"sort"
+)
+
+// discoverRegistryObjects lists the objects of a repository with the OCI distribution API, for the registries without
+// the GitHub Packages API. The tagged objects are listed first, then the untagged ones are discovered from them: the
+// manifests referenced by the image indices and the referrers (e.g. signatures and attestations). An untagged object
+// referenced by none of them cannot be discovered, as the distribution API does not list the manifests of a repository.
+// The package versions returned have no identifier.
+func discoverRegistryObjects(ctx context.Context, regClient ContainerRegistryClient, repository string) (
+	map[string]*github.PackageVersion,
+	map[string]v1.Image,
+	map[string]v1.ImageIndex,
+	error,
+) {
+	packageVersionByHash := make(map[string]*github.PackageVersion)
+	addVersion := func(hash string) (*github.PackageVersion, bool) {
+		if version, found := packageVersionByHash[hash]; found {
+			return version, false
+		}
+
+		version := &github.PackageVersion{
+			Name: github.String(hash),
+			Metadata: &github.PackageMetadata{
+				Container: &github.PackageContainerMetadata{},
+			},
+		}
+		packageVersionByHash[hash] = version
+		return version, true
+	}
+
+	// Resolve the tags, an unresolved tag must stop the cleaning as its object would be considered as untagged.
+	log.Debug().Str("repository", repository).Msg("listing all the repository tags")
+	tags, err := regClient.ListTags(ctx, repository)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	for _, tag := range tags {
+		if ctx.Err() != nil {
+			return nil, nil, nil, fmt.Errorf("unable to resolve the tags: %w", ctx.Err())
+		}
+
+		hash, err := regClient.GetTagHash(ctx, repository, tag)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		version, _ := addVersion(hash)
+		version.Metadata.Container.Tags = append(version.Metadata.Container.Tags, tag)
+	}
+
+	// Walk the objects to discover the untagged ones, in a stable order.
+	pending := make([]string, 0, len(packageVersionByHash))
+	for hash := range packageVersionByHash {
+		pending = append(pending, hash)
+	}
+	sort.Strings(pending)
+
+	imageByHash := make(map[string]v1.Image)
+	indexByHash := make(map[string]v1.ImageIndex)
+	for len(pending) > 0 {
+		hash := pending[0]
+		pending = pending[1:]
+
+		// Stop if the run has been cancelled.
+		if ctx.Err() != nil {
+			return nil, nil, nil, fmt.Errorf("unable to fetch the container registry objects: %w", ctx.Err())
+		}
+
+		log.Trace().Str("hash", hash).Msg("fetching container registry object")
+		image, index, err := regClient.GetRegistryObjectFromHash(ctx, repository, hash)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, nil, fmt.Errorf("unable to fetch the container registry objects: %w", ctx.Err())
+			}
+			log.Warn().Err(err).Msg("unable to retrieve container registry object")
+			continue
+		}
+
+		var discovered []string
+		if image != nil {
+			imageByHash[hash] = image
+		} else if index != nil {
+			indexByHash[hash] = index
+
+			// Discover the manifests referenced by the image index.
+			indexManifest, err := index.IndexManifest()
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("unable to get the image index manifest: %w", err)
+			}
+			for _, manifest := range indexManifest.Manifests {
+				discovered = append(discovered, manifest.Digest.String())
+			}
+		}
+
+		// Discover the referrers, not all the registries support them.
+		referrers, err := regClient.GetReferrers(ctx, repository, hash)
+		if err != nil {
+			log.Debug().Err(err).Str("hash", hash).Msg("unable to list the referrers")
+		}
+		discovered = append(discovered, referrers...)
+
+		for _, discoveredHash := range discovered {
+			if _, added := addVersion(discoveredHash); added {
+				log.Trace().Str("hash", discoveredHash).Str("from", hash).Msg("untagged container registry object discovered")
+				pending = append(pending, discoveredHash)
+			}
+		}
+	}
+
+	return packageVersionByHash, imageByHash, indexByHash, nil
+}
@@ -0,0 +1,76 @@
+package pkg
+
+import (
+	"context"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/suite"
+	"io"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+//
+// Test suite definition.
+//
+
+type DistributionTestSuite struct {
+	suite.Suite
+}
+
+func TestDistributionTestSuite(t *testing.T) {
+	suite.Run(t, new(DistributionTestSuite))
+}
+
+//
+// Tests.
+//
+
+func (s *DistributionTestSuite) TestDiscoverRegistryObjects() {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0)), registry.WithReferrersSupport(true)))
+	defer server.Close()
+	repository := strings.TrimPrefix(server.URL, "http://") + "/user/package"
+
+	r := s.Require()
+
+	// Push a multi-platform image index, tagged twice.
+	index, err := random.Index(64, 1, 2)
+	r.NoError(err)
+	for _, tag := range []string{"v1", "latest"} {
+		ref, err := name.NewTag(repository + ":" + tag)
+		r.NoError(err)
+		r.NoError(remote.WriteIndex(ref, index))
+	}
+	indexDigest, err := index.Digest()
+	r.NoError(err)
+	indexHash := indexDigest.String()
+
+	// Push a referrer of the image index.
+	referrerHash := pushReferrer(r, repository, indexHash)
+
+	// Discover the objects.
+	client, err := NewContainerRegistryClient("user", "password", DefaultRegistryRequestTimeout)
+	r.NoError(err)
+	versions, images, indices, err := discoverRegistryObjects(context.Background(), client, repository)
+	r.NoError(err)
+
+	// The tagged image index, its two images and the referrer are discovered.
+	r.Len(versions, 4)
+	r.ElementsMatch([]string{"v1", "latest"}, versions[indexHash].Metadata.Container.Tags)
+	r.Contains(indices, indexHash)
+	r.Len(images, 3)
+	r.Contains(images, referrerHash)
+
+	indexManifest, err := index.IndexManifest()
+	r.NoError(err)
+	for _, manifest := range indexManifest.Manifests {
+		hash := manifest.Digest.String()
+		r.Contains(images, hash)
+		r.Empty(versions[hash].Metadata.Container.Tags)
+		r.Nil(versions[hash].ID)
+	}
+}
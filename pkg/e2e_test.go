@@ -0,0 +1,590 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/pcasteran/ghcr-cleaning-action/pkg/githubtest"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/suite"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+//
+// Test suite definition.
+//
+
+type EndToEndTestSuite struct {
+	suite.Suite
+
+	// The in-memory container registry.
+	registry *httptest.Server
+
+	// The fake GitHub API.
+	github *githubtest.Server
+
+	ghClient          GithubClient
+	regClient         ContainerRegistryClient
+	pkgRegistryParams PackageRegistryParams
+}
+
+func TestEndToEndTestSuite(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	suite.Run(t, new(EndToEndTestSuite))
+}
+
+func (s *EndToEndTestSuite) SetupTest() {
+	s.registry = httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	s.github = githubtest.NewServer()
+
+	var err error
+	s.ghClient, err = NewGithubClient(context.Background(), "token", WithGithubBaseURL(s.github.BaseURL()))
+	s.Require().NoError(err)
+	s.regClient, err = NewContainerRegistryClient("user", "password", DefaultRegistryRequestTimeout)
+	s.Require().NoError(err)
+
+	s.pkgRegistryParams = PackageRegistryParams{
+		Registry:    strings.TrimPrefix(s.registry.URL, "http://"),
+		User:        "user",
+		PackageName: "package",
+	}
+}
+
+func (s *EndToEndTestSuite) TearDownTest() {
+	s.registry.Close()
+	s.github.Close()
+}
+
+//
+// Constants used in the tests.
+//
+
+var e2ePrFilterParams = PullRequestFilterParams{
+	Owner:      "owner",
+	Repository: "repository",
+	TagRegexes: []*regexp.Regexp{regexp.MustCompile(DefaultPrTagPattern)},
+}
+
+//
+// Tests.
+//
+
+func (s *EndToEndTestSuite) TestClean() {
+	s.github.AddPullRequest("owner", "repository", 1, "open")
+	s.github.AddPullRequest("owner", "repository", 2, "closed")
+
+	valid := s.pushImage("v1.0.0")
+	openPr := s.pushImage("pr-1")
+	closedPr := s.pushImage("pr-2")
+	untagged := s.pushImage("")
+	index, children := s.pushIndex("pr-2-multiarch")
+
+	plan, err := Clean(context.Background(), s.ghClient, e2ePrFilterParams, CommitFilterParams{}, LabelFilterParams{}, s.regClient, s.pkgRegistryParams, false)
+
+	// Check the result.
+	r := s.Require()
+	r.NoError(err)
+	r.ElementsMatch(append([]string{closedPr, untagged, index}, children...), plan.HashesToDelete())
+	r.ElementsMatch([]string{valid, openPr}, s.github.Versions("user", "package"))
+
+	deleted, notDeleted := plan.DeletionStatus()
+	r.Len(deleted, 5)
+	r.Empty(notDeleted)
+}
+
+func (s *EndToEndTestSuite) TestCleanPagination() {
+	// Create more versions than the size of a page.
+	var hashes []string
+	for i := 0; i < 150; i++ {
+		hashes = append(hashes, s.pushImage(""))
+	}
+
+	plan, err := Clean(context.Background(), s.ghClient, e2ePrFilterParams, CommitFilterParams{}, LabelFilterParams{}, s.regClient, s.pkgRegistryParams, false)
+
+	// Check the result, all the versions have been listed and deleted.
+	r := s.Require()
+	r.NoError(err)
+	r.ElementsMatch(hashes, plan.HashesToDelete())
+	r.Empty(s.github.Versions("user", "package"))
+}
+
+func (s *EndToEndTestSuite) TestCleanPartialFailure() {
+	s.pushImage("v1.0.0")
+	deletable := s.pushImage("")
+	undeletable := s.pushImage("")
+	s.github.FailDeletion(undeletable, http.StatusForbidden)
+
+	plan, err := Clean(context.Background(), s.ghClient, e2ePrFilterParams, CommitFilterParams{}, LabelFilterParams{}, s.regClient, s.pkgRegistryParams, false)
+
+	// Check the result, the plan tells which versions have not been deleted.
+	r := s.Require()
+	r.Error(err)
+	r.NotNil(plan)
+
+	deleted, notDeleted := plan.DeletionStatus()
+	r.Equal([]string{deletable}, deleted)
+	r.Equal([]string{undeletable}, notDeleted)
+	r.Contains(s.github.Versions("user", "package"), undeletable)
+}
+
+func (s *EndToEndTestSuite) TestCleanDryRun() {
+	s.pushImage("v1.0.0")
+	untagged := s.pushImage("")
+
+	plan, err := Clean(context.Background(), s.ghClient, e2ePrFilterParams, CommitFilterParams{}, LabelFilterParams{}, s.regClient, s.pkgRegistryParams, true)
+
+	// Check the result, nothing has been deleted.
+	r := s.Require()
+	r.NoError(err)
+	r.Equal([]string{untagged}, plan.HashesToDelete())
+	r.Len(s.github.Versions("user", "package"), 2)
+
+	deleted, _ := plan.DeletionStatus()
+	r.Empty(deleted)
+}
+
+func (s *EndToEndTestSuite) TestCleanRegistryDeletion() {
+	s.pushImage("v1.0.0")
+	untagged := s.pushImage("")
+
+	pkgRegistryParams := s.pkgRegistryParams
+	pkgRegistryParams.DeletionStrategy = RegistryDeletion
+	plan, err := Clean(context.Background(), s.ghClient, e2ePrFilterParams, CommitFilterParams{}, LabelFilterParams{}, s.regClient, pkgRegistryParams, false)
+
+	// Check the result, the registry object has been deleted.
+	r := s.Require()
+	r.NoError(err)
+	r.Equal([]string{untagged}, plan.HashesToDelete())
+
+	_, _, err = s.regClient.GetRegistryObjectFromHash(context.Background(), s.repository(), untagged)
+	r.Error(err)
+}
+
+func (s *EndToEndTestSuite) TestExplain() {
+	s.github.AddPullRequest("owner", "repository", 2, "closed")
+	index, children := s.pushIndex("pr-2")
+
+	// Explain the decision taken for the index, by tag.
+	r := s.Require()
+	explanation, err := Explain(context.Background(), s.ghClient, e2ePrFilterParams, CommitFilterParams{}, LabelFilterParams{}, s.regClient, s.pkgRegistryParams, "pr-2")
+	r.NoError(err)
+	r.Equal(index, explanation.Hash)
+	r.NotZero(explanation.VersionID)
+	r.Equal([]string{"pr-2"}, explanation.Tags)
+	r.Empty(explanation.Parents)
+	r.ElementsMatch(children, explanation.Children)
+	r.Equal([]Verdict{{
+		Policy:      "pull request tag",
+		Subject:     "pr-2",
+		Verdict:     "obsolete",
+		Detail:      "pull request owner/repository#2 is closed",
+		PullRequest: &PullRequestState{Owner: "owner", Repository: "repository", Number: 2, State: "closed"},
+	}}, explanation.Verdicts)
+	r.Equal([]PullRequestState{{Owner: "owner", Repository: "repository", Number: 2, State: "closed"}}, explanation.PullRequests)
+	r.True(explanation.Decision.Delete)
+	r.Equal("all tags obsolete", explanation.Decision.Reason)
+
+	// Explain the decision taken for a child, by digest.
+	explanation, err = Explain(context.Background(), s.ghClient, e2ePrFilterParams, CommitFilterParams{}, LabelFilterParams{}, s.regClient, s.pkgRegistryParams, children[0])
+	r.NoError(err)
+	r.Equal([]string{index}, explanation.Parents)
+	r.True(explanation.Decision.Delete)
+	r.Equal("deleted because its only parent "+index+" was deleted", explanation.Decision.Provenance)
+
+	// Nothing has been deleted.
+	r.Len(s.github.Versions("user", "package"), 3)
+
+	// Explain an unknown tag.
+	_, err = Explain(context.Background(), s.ghClient, e2ePrFilterParams, CommitFilterParams{}, LabelFilterParams{}, s.regClient, s.pkgRegistryParams, "unknown")
+	r.ErrorContains(err, "no version of package 'package' found for 'unknown'")
+}
+
+func (s *EndToEndTestSuite) TestExplainAllTags() {
+	s.github.AddPullRequest("owner", "repository", 2, "closed")
+	image := s.pushImage("v1.0.0")
+	s.github.SetTags("user", "package", image, "v1.0.0", "pr-2")
+
+	// The verdicts on all the tags are reported, even after the first valid one.
+	r := s.Require()
+	explanation, err := Explain(context.Background(), s.ghClient, e2ePrFilterParams, CommitFilterParams{}, LabelFilterParams{}, s.regClient, s.pkgRegistryParams, image)
+	r.NoError(err)
+	r.Len(explanation.Verdicts, 2)
+	r.Equal("v1.0.0", explanation.Verdicts[0].Subject)
+	r.Equal("valid", explanation.Verdicts[0].Verdict)
+	r.Equal("pr-2", explanation.Verdicts[1].Subject)
+	r.Equal("obsolete", explanation.Verdicts[1].Verdict)
+	r.Equal([]PullRequestState{{Owner: "owner", Repository: "repository", Number: 2, State: "closed"}}, explanation.PullRequests)
+	r.False(explanation.Decision.Delete)
+	r.Equal("valid tags", explanation.Decision.Reason)
+}
+
+func (s *EndToEndTestSuite) TestInventory() {
+	image := s.pushImage("v1")
+	index, children := s.pushIndex("v2")
+
+	r := s.Require()
+	items, err := Inventory(context.Background(), s.ghClient, s.regClient, s.pkgRegistryParams)
+	r.NoError(err)
+	r.Len(items, 4)
+
+	itemByDigest := make(map[string]*InventoryItem)
+	for _, item := range items {
+		r.NotZero(item.VersionID)
+		r.NotNil(item.CreatedAt)
+		r.NotNil(item.UpdatedAt)
+		r.Positive(item.Size)
+		itemByDigest[item.Digest] = item
+	}
+
+	r.Equal(ImageKind, itemByDigest[image].Kind)
+	r.Equal([]string{"v1"}, itemByDigest[image].Tags)
+	r.Empty(itemByDigest[image].Parents)
+
+	r.Equal(IndexKind, itemByDigest[index].Kind)
+	r.Equal([]string{"v2"}, itemByDigest[index].Tags)
+	r.Empty(itemByDigest[index].Parents)
+
+	for _, child := range children {
+		r.Equal(ImageKind, itemByDigest[child].Kind)
+		r.Empty(itemByDigest[child].Tags)
+		r.Equal([]string{index}, itemByDigest[child].Parents)
+	}
+
+	// Nothing has been deleted.
+	r.Len(s.github.Versions("user", "package"), 4)
+}
+
+func (s *EndToEndTestSuite) TestInventoryReferrers() {
+	image := s.pushImage("v1")
+	signature := s.pushReferrer(image, "application/vnd.dev.cosign.simplesigning.v1+json")
+	attestation := s.pushReferrer(image, "application/vnd.dsse.envelope.v1+json")
+
+	r := s.Require()
+	items, err := Inventory(context.Background(), s.ghClient, s.regClient, s.pkgRegistryParams)
+	r.NoError(err)
+	r.Len(items, 3)
+
+	kindByDigest := make(map[string]string)
+	for _, item := range items {
+		kindByDigest[item.Digest] = item.Kind
+		if item.Digest != image {
+			r.Equal([]string{image}, item.Parents)
+			r.Empty(item.Platforms)
+		}
+	}
+	r.Equal(map[string]string{image: ImageKind, signature: SignatureKind, attestation: AttestationKind}, kindByDigest)
+}
+
+func (s *EndToEndTestSuite) TestCleanPrunedPlatforms() {
+	index, manifests := s.pushMultiPlatformIndex("v1", "linux/amd64", "linux/386", "linux/arm/v6", "linux/arm64/v8")
+
+	pkgRegistryParams := s.pkgRegistryParams
+	pkgRegistryParams.PrunedPlatforms = []string{"linux/386", "linux/arm"}
+	plan, err := Clean(context.Background(), s.ghClient, e2ePrFilterParams, CommitFilterParams{}, LabelFilterParams{}, s.regClient, pkgRegistryParams, false)
+
+	// Check the result, the old index and the manifests of the pruned platforms have been deleted.
+	r := s.Require()
+	r.NoError(err)
+	r.ElementsMatch([]string{index, manifests["linux/386"], manifests["linux/arm/v6"]}, plan.HashesToDelete())
+	r.Equal(index, plan.HashesToDelete()[0])
+	r.ElementsMatch([]string{manifests["linux/amd64"], manifests["linux/arm64/v8"]}, s.github.Versions("user", "package"))
+
+	// The new index has been pushed under the same tag, and the old one is recorded in the plan.
+	r.Len(plan.Rewrites, 1)
+	rewrite := plan.Rewrites[0]
+	r.Equal(index, rewrite.OldHash)
+	r.Equal([]string{"v1"}, rewrite.Tags)
+	r.Equal([]string{"linux/386", "linux/arm/v6"}, rewrite.PrunedPlatforms)
+	r.ElementsMatch([]string{manifests["linux/386"], manifests["linux/arm/v6"]}, rewrite.PrunedManifests)
+	r.True(rewrite.Pushed)
+
+	hash, err := s.regClient.GetTagHash(context.Background(), s.repository(), "v1")
+	r.NoError(err)
+	r.Equal(rewrite.NewHash, hash)
+
+	_, newIndex, err := s.regClient.GetRegistryObjectFromHash(context.Background(), s.repository(), hash)
+	r.NoError(err)
+	newIndexManifest, err := newIndex.IndexManifest()
+	r.NoError(err)
+	var platforms []string
+	for _, manifest := range newIndexManifest.Manifests {
+		platforms = append(platforms, manifest.Platform.String())
+	}
+	r.Equal([]string{"linux/amd64", "linux/arm64/v8"}, platforms)
+}
+
+func (s *EndToEndTestSuite) TestCleanPrunedPlatformsDryRun() {
+	index, manifests := s.pushMultiPlatformIndex("v1", "linux/amd64", "linux/386")
+
+	pkgRegistryParams := s.pkgRegistryParams
+	pkgRegistryParams.PrunedPlatforms = []string{"linux/386"}
+	plan, err := Clean(context.Background(), s.ghClient, e2ePrFilterParams, CommitFilterParams{}, LabelFilterParams{}, s.regClient, pkgRegistryParams, true)
+
+	// Check the result, the plan is the one of a real run but nothing has been pushed nor deleted.
+	r := s.Require()
+	r.NoError(err)
+	r.Equal([]string{index, manifests["linux/386"]}, plan.HashesToDelete())
+	r.Len(plan.Rewrites, 1)
+	r.False(plan.Rewrites[0].Pushed)
+	r.Len(s.github.Versions("user", "package"), 3)
+
+	hash, err := s.regClient.GetTagHash(context.Background(), s.repository(), "v1")
+	r.NoError(err)
+	r.Equal(index, hash)
+}
+
+func (s *EndToEndTestSuite) TestCleanPrunedPlatformsAll() {
+	index, _ := s.pushMultiPlatformIndex("v1", "linux/386")
+
+	pkgRegistryParams := s.pkgRegistryParams
+	pkgRegistryParams.PrunedPlatforms = []string{"linux/386"}
+	plan, err := Clean(context.Background(), s.ghClient, e2ePrFilterParams, CommitFilterParams{}, LabelFilterParams{}, s.regClient, pkgRegistryParams, false)
+
+	// Check the result, an index is never emptied.
+	r := s.Require()
+	r.NoError(err)
+	r.Empty(plan.HashesToDelete())
+	r.Empty(plan.Rewrites)
+
+	hash, err := s.regClient.GetTagHash(context.Background(), s.repository(), "v1")
+	r.NoError(err)
+	r.Equal(index, hash)
+}
+
+func (s *EndToEndTestSuite) TestCleanPrunedPlatformsSigned() {
+	index, _ := s.pushMultiPlatformIndex("v1", "linux/amd64", "linux/386")
+	s.pushReferrer(index, "application/vnd.dev.cosign.simplesigning.v1+json")
+
+	pkgRegistryParams := s.pkgRegistryParams
+	pkgRegistryParams.PrunedPlatforms = []string{"linux/386"}
+	plan, err := Clean(context.Background(), s.ghClient, e2ePrFilterParams, CommitFilterParams{}, LabelFilterParams{}, s.regClient, pkgRegistryParams, false)
+
+	// Check the result, the signature of the index would be orphaned so it is left untouched.
+	r := s.Require()
+	r.NoError(err)
+	r.Empty(plan.HashesToDelete())
+	r.Empty(plan.Rewrites)
+}
+
+func (s *EndToEndTestSuite) TestCleanPrunedPlatformsKeepMarker() {
+	index, _ := s.pushAnnotatedMultiPlatformIndex("v1", map[string]string{KeepMarker: "true"}, "linux/amd64", "linux/386")
+
+	pkgRegistryParams := s.pkgRegistryParams
+	pkgRegistryParams.PrunedPlatforms = []string{"linux/386"}
+	plan, err := Clean(context.Background(), s.ghClient, e2ePrFilterParams, CommitFilterParams{}, LabelFilterParams{}, s.regClient, pkgRegistryParams, false)
+
+	// Check the result, the index is protected so it is left untouched.
+	r := s.Require()
+	r.NoError(err)
+	r.Empty(plan.HashesToDelete())
+	r.Empty(plan.Rewrites)
+
+	hash, err := s.regClient.GetTagHash(context.Background(), s.repository(), "v1")
+	r.NoError(err)
+	r.Equal(index, hash)
+}
+
+func (s *EndToEndTestSuite) TestPruneIndexPlatformsObjectsUntouched() {
+	index, _ := s.pushMultiPlatformIndex("v1", "linux/amd64", "linux/386")
+
+	r := s.Require()
+	versions, images, indices, err := fetchObjects(context.Background(), s.ghClient, s.regClient, s.pkgRegistryParams, s.repository())
+	r.NoError(err)
+	plan, items, err := evaluatePackage(context.Background(), s.ghClient, e2ePrFilterParams, CommitFilterParams{}, LabelFilterParams{}, versions, images, indices, evaluationOptions{})
+	r.NoError(err)
+
+	platforms, err := parsePlatforms([]string{"linux/386"})
+	r.NoError(err)
+	rewrites, prunedVersions, prunedIndices, err := pruneIndexPlatforms(context.Background(), s.regClient, s.repository(), platforms, plan, items, versions, indices, true)
+
+	// Check the result, the updated objects are returned and the given ones are left untouched.
+	r.NoError(err)
+	r.Len(rewrites, 1)
+	r.Len(versions, 3)
+	r.Len(indices, 1)
+	r.Equal([]string{"v1"}, versions[index].GetMetadata().GetContainer().Tags)
+
+	r.Len(prunedVersions, 4)
+	r.Len(prunedIndices, 2)
+	r.Empty(prunedVersions[index].GetMetadata().GetContainer().Tags)
+	r.Equal([]string{"v1"}, prunedVersions[rewrites[0].NewHash].GetMetadata().GetContainer().Tags)
+}
+
+func (s *EndToEndTestSuite) TestCleanPrunedPlatformsChecksOnce() {
+	s.github.AddPullRequest("owner", "repository", 1, "open")
+	openPr := s.pushImage("pr-1")
+	index, _ := s.pushMultiPlatformIndex("v1", "linux/amd64", "linux/386")
+
+	ghClient := &pullRequestCountingClient{GithubClient: s.ghClient}
+	pkgRegistryParams := s.pkgRegistryParams
+	pkgRegistryParams.PrunedPlatforms = []string{"linux/386"}
+	plan, err := Clean(context.Background(), ghClient, e2ePrFilterParams, CommitFilterParams{}, LabelFilterParams{}, s.regClient, pkgRegistryParams, false)
+
+	// Check the result, the pull request is only retrieved by the first evaluation.
+	r := s.Require()
+	r.NoError(err)
+	r.Len(plan.Rewrites, 1)
+	r.Contains(plan.HashesToDelete(), index)
+	r.NotContains(plan.HashesToDelete(), openPr)
+	r.Equal(1, ghClient.pullRequestStates)
+}
+
+func (s *EndToEndTestSuite) TestCleanInvalidPrunedPlatform() {
+	pkgRegistryParams := s.pkgRegistryParams
+	pkgRegistryParams.PrunedPlatforms = []string{"linux"}
+	_, err := Clean(context.Background(), s.ghClient, e2ePrFilterParams, CommitFilterParams{}, LabelFilterParams{}, s.regClient, pkgRegistryParams, false)
+
+	r := s.Require()
+	r.ErrorContains(err, "invalid platform 'linux', must be os/architecture[/variant]")
+}
+
+//
+// Helpers.
+//
+
+// repository returns the repository of the package in the in-memory registry.
+func (s *EndToEndTestSuite) repository() string {
+	return fmt.Sprintf("%s/%s/%s", s.pkgRegistryParams.Registry, s.pkgRegistryParams.User, s.pkgRegistryParams.PackageName)
+}
+
+// pushImage pushes a random image, tagged if the tag is not empty, adds the corresponding package version and returns
+// its hash.
+func (s *EndToEndTestSuite) pushImage(tag string) string {
+	r := s.Require()
+
+	image, err := random.Image(64, 1)
+	r.NoError(err)
+	digest, err := image.Digest()
+	r.NoError(err)
+
+	var ref name.Reference
+	if tag != "" {
+		ref, err = name.NewTag(s.repository() + ":" + tag)
+	} else {
+		ref, err = name.NewDigest(s.repository() + "@" + digest.String())
+	}
+	r.NoError(err)
+	r.NoError(remote.Write(ref, image))
+
+	var tags []string
+	if tag != "" {
+		tags = []string{tag}
+	}
+	s.github.AddVersion("user", "package", digest.String(), tags...)
+
+	return digest.String()
+}
+
+// pushReferrer pushes an untagged image referring to a subject, with a layer of a media type, adds the corresponding
+// package version and returns its hash.
+func (s *EndToEndTestSuite) pushReferrer(subject string, layerMediaType types.MediaType) string {
+	r := s.Require()
+
+	subjectDigest, err := v1.NewHash(subject)
+	r.NoError(err)
+	image, err := mutate.AppendLayers(empty.Image, static.NewLayer([]byte(subject), layerMediaType))
+	r.NoError(err)
+	image = mutate.Subject(image, v1.Descriptor{MediaType: types.OCIManifestSchema1, Digest: subjectDigest}).(v1.Image)
+	digest, err := image.Digest()
+	r.NoError(err)
+
+	ref, err := name.NewDigest(s.repository() + "@" + digest.String())
+	r.NoError(err)
+	r.NoError(remote.Write(ref, image))
+	s.github.AddVersion("user", "package", digest.String())
+
+	return digest.String()
+}
+
+// pushMultiPlatformIndex pushes a tagged image index of random images, one per platform, adds the corresponding package
+// versions and returns the hash of the index and of its images by platform.
+func (s *EndToEndTestSuite) pushMultiPlatformIndex(tag string, platforms ...string) (string, map[string]string) {
+	return s.pushAnnotatedMultiPlatformIndex(tag, nil, platforms...)
+}
+
+// pushAnnotatedMultiPlatformIndex is like pushMultiPlatformIndex, the index having annotations.
+func (s *EndToEndTestSuite) pushAnnotatedMultiPlatformIndex(tag string, annotations map[string]string, platforms ...string) (string, map[string]string) {
+	r := s.Require()
+
+	var index v1.ImageIndex = empty.Index
+	manifests := make(map[string]string)
+	for _, platform := range platforms {
+		image, err := random.Image(64, 1)
+		r.NoError(err)
+		digest, err := image.Digest()
+		r.NoError(err)
+		parsedPlatform, err := v1.ParsePlatform(platform)
+		r.NoError(err)
+
+		index = mutate.AppendManifests(index, mutate.IndexAddendum{
+			Add:        image,
+			Descriptor: v1.Descriptor{Platform: parsedPlatform},
+		})
+		s.github.AddVersion("user", "package", digest.String())
+		manifests[platform] = digest.String()
+	}
+	if annotations != nil {
+		index = mutate.Annotations(index, annotations).(v1.ImageIndex)
+	}
+
+	ref, err := name.NewTag(s.repository() + ":" + tag)
+	r.NoError(err)
+	r.NoError(remote.WriteIndex(ref, index))
+
+	digest, err := index.Digest()
+	r.NoError(err)
+	s.github.AddVersion("user", "package", digest.String(), tag)
+
+	return digest.String(), manifests
+}
+
+// pushIndex pushes a tagged random image index of two images, adds the corresponding package versions and returns the
+// hash of the index and of its images.
+func (s *EndToEndTestSuite) pushIndex(tag string) (string, []string) {
+	r := s.Require()
+
+	index, err := random.Index(64, 1, 2)
+	r.NoError(err)
+	ref, err := name.NewTag(s.repository() + ":" + tag)
+	r.NoError(err)
+	r.NoError(remote.WriteIndex(ref, index))
+
+	indexManifest, err := index.IndexManifest()
+	r.NoError(err)
+	var children []string
+	for _, manifest := range indexManifest.Manifests {
+		s.github.AddVersion("user", "package", manifest.Digest.String())
+		children = append(children, manifest.Digest.String())
+	}
+
+	digest, err := index.Digest()
+	r.NoError(err)
+	s.github.AddVersion("user", "package", digest.String(), tag)
+
+	return digest.String(), children
+}
+
+// pullRequestCountingClient is a GitHub client counting the retrievals of the pull request states.
+type pullRequestCountingClient struct {
+	GithubClient
+	pullRequestStates int
+}
+
+func (c *pullRequestCountingClient) GetPullRequestState(ctx context.Context, owner, repository string, number int) (string, error) {
+	c.pullRequestStates++
+	return c.GithubClient.GetPullRequestState(ctx, owner, repository, number)
+}
@@ -0,0 +1,140 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"sort"
+	"strings"
+)
+
+// Verdict is the verdict of a policy on a package version, e.g. the one of the pull request tags policy on a tag.
+type Verdict struct {
+	// Policy is the policy giving the verdict: "keep marker", "pull request tag", "commit tag", "revision" or "tag".
+	Policy string `json:"policy"`
+
+	// Subject is what the verdict is about, e.g. a tag.
+	Subject string `json:"subject,omitempty"`
+
+	// Verdict is either "keep", "valid", "obsolete" or "error".
+	Verdict string `json:"verdict"`
+	Detail  string `json:"detail,omitempty"`
+
+	// PullRequest is the pull request consulted by the pull request tags policy.
+	PullRequest *PullRequestState `json:"pullRequest,omitempty"`
+}
+
+// PullRequestState is the state of a pull request.
+type PullRequestState struct {
+	Owner      string `json:"owner"`
+	Repository string `json:"repository"`
+	Number     int    `json:"number"`
+	State      string `json:"state"`
+}
+
+// with returns the verdict set to valid or obsolete, with a detail.
+func (v Verdict) with(obsolete bool, detail string) Verdict {
+	v.Verdict = "valid"
+	if obsolete {
+		v.Verdict = "obsolete"
+	}
+	v.Detail = detail
+	return v
+}
+
+// withError returns the verdict set to error, the error being the detail.
+func (v Verdict) withError(err error) Verdict {
+	v.Verdict = "error"
+	v.Detail = err.Error()
+	return v
+}
+
+// Explanation is the explanation of the decision taken for a package version.
+type Explanation struct {
+	Hash      string   `json:"hash"`
+	VersionID int64    `json:"versionId,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+
+	// Parents are the hashes of the objects referencing the package version: the image indices, or its subject if it is
+	// a referrer. Children are the hashes of the objects it references: the manifests if it is an image index, and its
+	// referrers.
+	Parents  []string `json:"parents,omitempty"`
+	Children []string `json:"children,omitempty"`
+
+	Verdicts     []Verdict          `json:"verdicts,omitempty"`
+	PullRequests []PullRequestState `json:"pullRequests,omitempty"`
+	Decision     *Decision          `json:"decision"`
+}
+
+// Explain explains the decision taken for the package version having a tag or a digest, by evaluating the package
+// like Clean does but without deleting anything.
+func Explain(ctx context.Context, ghClient GithubClient, prFilterParams PullRequestFilterParams, commitFilterParams CommitFilterParams, labelFilterParams LabelFilterParams, regClient ContainerRegistryClient, pkgRegistryParams PackageRegistryParams, tagOrDigest string) (*Explanation, error) {
+	// Check the permissions before doing anything, nothing is deleted.
+	log.Debug().Msg("performing the preflight check")
+	err := Preflight(ctx, ghClient, prFilterParams, commitFilterParams, labelFilterParams, pkgRegistryParams, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// List the package versions and get their registry object (image or image index).
+	packageVersionByHash, imageByHash, indexByHash, err := fetchObjects(ctx, ghClient, regClient, pkgRegistryParams, pkgRegistryParams.getRepository())
+	if err != nil {
+		return nil, err
+	}
+
+	// Find the package version.
+	hash := ""
+	if strings.HasPrefix(tagOrDigest, "sha256:") {
+		if _, found := packageVersionByHash[tagOrDigest]; found {
+			hash = tagOrDigest
+		}
+	} else {
+		for versionHash, version := range packageVersionByHash {
+			for _, tag := range version.GetMetadata().GetContainer().Tags {
+				if tag == tagOrDigest {
+					hash = versionHash
+				}
+			}
+		}
+	}
+	if hash == "" {
+		return nil, fmt.Errorf("no version of package '%s' found for '%s'", pkgRegistryParams.PackageName, tagOrDigest)
+	}
+
+	// Evaluate the whole package, as the decision depends on the ones taken for the related objects. All the tags are
+	// checked to report their verdicts.
+	plan, items, err := evaluatePackage(ctx, ghClient, prFilterParams, commitFilterParams, labelFilterParams, packageVersionByHash, imageByHash, indexByHash, evaluationOptions{allVerdicts: true})
+	if err != nil {
+		return nil, fmt.Errorf("unable to compute the cleaning plan: %w", err)
+	}
+
+	version := packageVersionByHash[hash]
+	explanation := &Explanation{
+		Hash:      hash,
+		VersionID: version.GetID(),
+		Tags:      version.GetMetadata().GetContainer().Tags,
+	}
+	for _, decision := range plan.Decisions {
+		if decision.Hash == hash {
+			explanation.Decision = decision
+		}
+	}
+
+	// The registry object of the package version may not have been retrieved.
+	if item, found := items[hash]; found {
+		explanation.Parents = sortedCopy(item.parents)
+		for _, child := range item.references {
+			explanation.Children = append(explanation.Children, child.hash)
+		}
+		sort.Strings(explanation.Children)
+
+		explanation.Verdicts = item.verdicts
+		for _, verdict := range item.verdicts {
+			if verdict.PullRequest != nil {
+				explanation.PullRequests = append(explanation.PullRequests, *verdict.PullRequest)
+			}
+		}
+	}
+
+	return explanation, nil
+}
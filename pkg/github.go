@@ -2,45 +2,206 @@ package pkg
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/google/go-github/v49/github"
 	"golang.org/x/oauth2"
+	"net/http"
+	"net/url"
+	"strings"
 )
 
 type GithubClient interface {
-	GetAllContainerPackages(user string) ([]*github.Package, error)
+	GetAllContainerPackages(ctx context.Context, user string) ([]*github.Package, error)
 
-	GetAllContainerPackageVersions(user, packageName string) ([]*github.PackageVersion, error)
+	GetAllContainerPackageVersions(ctx context.Context, user, packageName string) ([]*github.PackageVersion, error)
 
-	DeleteContainerPackageVersion(user, packageName string, id int64) error
+	DeleteContainerPackageVersion(ctx context.Context, user, packageName string, id int64) error
 
-	GetPullRequestState(owner, repository string, id int) (string, error)
+	GetPullRequestState(ctx context.Context, owner, repository string, id int) (string, error)
+
+	GetAllBranches(ctx context.Context, owner, repository string) ([]*github.Branch, error)
+
+	GetAllTags(ctx context.Context, owner, repository string) ([]*github.RepositoryTag, error)
+
+	GetCommitComparisonStatus(ctx context.Context, owner, repository, base, head string) (string, error)
+
+	GetAllPullRequestsForCommit(ctx context.Context, owner, repository, sha string) ([]*github.PullRequest, error)
+
+	GetContainerPackage(ctx context.Context, user, packageName string) (*github.Package, error)
+
+	GetLatestContainerPackageVersion(ctx context.Context, user, packageName string) (*github.PackageVersion, error)
+
+	GetLatestPullRequest(ctx context.Context, owner, repository string) (*github.PullRequest, error)
+
+	GetTokenScopes(ctx context.Context) (TokenScopes, error)
+
+	GetRateLimits(ctx context.Context) (RateLimits, error)
+}
+
+// RateLimits are the core API rate limits of the tokens used by the GitHub client.
+type RateLimits struct {
+	Packages     *github.Rate
+	Repositories *github.Rate
+}
+
+// TokenScopes are the OAuth scopes granted to the tokens used by the GitHub client. The scopes are only known for the
+// classic personal access tokens and OAuth tokens, they are nil otherwise (e.g. for fine-grained tokens and GitHub App
+// installation tokens).
+type TokenScopes struct {
+	Packages     []string
+	Repositories []string
 }
 
 type githubClientImpl struct {
-	ctx    context.Context
+	// The client used for the packages API.
 	client *github.Client
+
+	// The client used for the repositories API (pull requests, branches, tags and commits).
+	repoClient *github.Client
+}
+
+// GithubClientOption is an option of the GitHub client.
+type GithubClientOption func(*githubClientOptions)
+
+type githubClientOptions struct {
+	// The base URL of the GitHub API, the public GitHub API if empty.
+	baseURL string
+
+	// The upload URL of the GitHub API, only used for GitHub Enterprise Server.
+	uploadURL string
+
+	// Whether the base URL is the one of a GitHub Enterprise Server.
+	enterprise bool
+}
+
+// WithGithubBaseURL makes the GitHub client target the GitHub API at a base URL, e.g. a fake one in the tests.
+func WithGithubBaseURL(baseURL string) GithubClientOption {
+	return func(options *githubClientOptions) {
+		options.baseURL = baseURL
+	}
+}
+
+// WithGithubEnterpriseURLs makes the GitHub client target the API of a GitHub Enterprise Server. The `/api/v3/` path is
+// appended to the base URL if missing, and the upload URL defaults to the one of the host of the base URL if empty.
+func WithGithubEnterpriseURLs(baseURL, uploadURL string) GithubClientOption {
+	return func(options *githubClientOptions) {
+		options.baseURL = baseURL
+		options.uploadURL = uploadURL
+		options.enterprise = true
+	}
 }
 
 // NewGithubClient returns an initialized GitHub client
-func NewGithubClient(ctx context.Context, token string) (GithubClient, error) {
-	// Create a new http.Client that will manage the authentication.
+func NewGithubClient(ctx context.Context, token string, opts ...GithubClientOption) (GithubClient, error) {
 	tokenSource := oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: token},
 	)
-	httpClient := oauth2.NewClient(ctx, tokenSource)
+	return NewGithubClientFromTokenSources(ctx, tokenSource, tokenSource, opts...)
+}
+
+// NewGithubClientFromTokenSources returns an initialized GitHub client, authenticated with the tokens of a token source
+// for the packages API and of another one for the repositories API
+func NewGithubClientFromTokenSources(ctx context.Context, packagesTokenSource, repositoriesTokenSource oauth2.TokenSource, opts ...GithubClientOption) (GithubClient, error) {
+	options := &githubClientOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
 
-	// Create the GitHub client.
-	githubClient := github.NewClient(httpClient)
+	// Create the GitHub clients, using a new http.Client that will manage the authentication and the retries.
+	githubClient, err := newGithubAPIClient(newRetryingClient(ctx, packagesTokenSource), options)
+	if err != nil {
+		return nil, err
+	}
+	githubRepoClient, err := newGithubAPIClient(newRetryingClient(ctx, repositoriesTokenSource), options)
+	if err != nil {
+		return nil, err
+	}
 
 	return &githubClientImpl{
-		ctx:    ctx,
-		client: githubClient,
+		client:     githubClient,
+		repoClient: githubRepoClient,
 	}, nil
 }
 
+// newGithubAPIClient returns a client of the GitHub API, targeting the base URL of the options if any.
+func newGithubAPIClient(httpClient *http.Client, options *githubClientOptions) (*github.Client, error) {
+	if options.enterprise {
+		return newGithubEnterpriseClient(httpClient, options.baseURL, options.uploadURL)
+	}
+
+	client := github.NewClient(httpClient)
+	if options.baseURL == "" {
+		return client, nil
+	}
+
+	// The base URL must have a trailing slash.
+	baseURL, err := url.Parse(strings.TrimSuffix(options.baseURL, "/") + "/")
+	if err != nil {
+		return nil, fmt.Errorf("invalid GitHub API base URL '%s': %w", options.baseURL, err)
+	}
+	client.BaseURL = baseURL
+
+	return client, nil
+}
+
+// newRetryingClient returns an http.Client authenticated with a token source and retrying the requests on rate limits
+// and transient errors.
+func newRetryingClient(ctx context.Context, tokenSource oauth2.TokenSource) *http.Client {
+	return &http.Client{
+		Transport: newGithubRetryTransport(oauth2.NewClient(ctx, tokenSource).Transport),
+	}
+}
+
+// newGithubEnterpriseClient returns a client of the API of a GitHub Enterprise Server.
+func newGithubEnterpriseClient(httpClient *http.Client, baseURL, uploadURL string) (*github.Client, error) {
+	if uploadURL == "" {
+		// The upload API is served at `/api/uploads/` on the host of the API.
+		u, err := url.Parse(baseURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GitHub API base URL '%s': %w", baseURL, err)
+		}
+		uploadURL = (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/api/uploads/"}).String()
+	}
+
+	client, err := github.NewEnterpriseClient(baseURL, uploadURL, httpClient)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GitHub Enterprise Server API URLs '%s' and '%s': %w", baseURL, uploadURL, err)
+	}
+	return client, nil
+}
+
+// IsPublicGithubAPIURL returns whether a GitHub API URL is the one of the public GitHub API, an empty URL being the
+// default public one.
+func IsPublicGithubAPIURL(apiURL string) bool {
+	if apiURL == "" {
+		return true
+	}
+
+	u, err := url.Parse(apiURL)
+	return err == nil && strings.EqualFold(u.Hostname(), "api.github.com")
+}
+
+// GetContainerRegistryHost returns the host of the container registry of a GitHub instance from the URL of its API:
+// ghcr.io for the public GitHub, `containers.HOSTNAME` for a GitHub Enterprise Server, HOSTNAME being the one of the
+// instance.
+func GetContainerRegistryHost(apiURL string) (string, error) {
+	if IsPublicGithubAPIURL(apiURL) {
+		return DefaultRegistry, nil
+	}
+
+	u, err := url.Parse(apiURL)
+	if err != nil || u.Hostname() == "" {
+		return "", fmt.Errorf("invalid GitHub API URL '%s'", apiURL)
+	}
+
+	// The API may be served on a dedicated subdomain, e.g. for GitHub Enterprise Cloud with data residency.
+	hostname := strings.TrimPrefix(strings.ToLower(u.Hostname()), "api.")
+	return "containers." + hostname, nil
+}
+
 // GetAllContainerPackages returns all the active packages of type container
-func (gh *githubClientImpl) GetAllContainerPackages(user string) ([]*github.Package, error) {
+func (gh *githubClientImpl) GetAllContainerPackages(ctx context.Context, user string) ([]*github.Package, error) {
 	// Create an empty list of GitHub packages.
 	var packages []*github.Package
 
@@ -55,9 +216,9 @@ func (gh *githubClientImpl) GetAllContainerPackages(user string) ([]*github.Pack
 
 	for {
 		// Get the next page.
-		pkgs, response, err := gh.client.Users.ListPackages(gh.ctx, user, listOptions)
+		pkgs, response, err := gh.client.Users.ListPackages(ctx, user, listOptions)
 		if err != nil {
-			return nil, fmt.Errorf("unable to list container packages for user '%s': %w", user, err)
+			return nil, fmt.Errorf("unable to list container packages for user '%s': %w", user, withScopeHint(err, "read:packages"))
 		}
 
 		// Add the page content to the result list.
@@ -74,7 +235,7 @@ func (gh *githubClientImpl) GetAllContainerPackages(user string) ([]*github.Pack
 }
 
 // GetAllContainerPackageVersions returns all the versions of a package of type container
-func (gh *githubClientImpl) GetAllContainerPackageVersions(user, packageName string) ([]*github.PackageVersion, error) {
+func (gh *githubClientImpl) GetAllContainerPackageVersions(ctx context.Context, user, packageName string) ([]*github.PackageVersion, error) {
 	// Create an empty list of GitHub package versions.
 	var packageVersions []*github.PackageVersion
 
@@ -90,14 +251,14 @@ func (gh *githubClientImpl) GetAllContainerPackageVersions(user, packageName str
 	for {
 		// Get the next page.
 		pkgVersions, response, err := gh.client.Users.PackageGetAllVersions(
-			gh.ctx,
+			ctx,
 			user,
 			*listOptions.PackageType,
 			packageName,
 			listOptions,
 		)
 		if err != nil {
-			return nil, fmt.Errorf("unable to list container package versions for user '%s' and package '%s': %w", user, packageName, err)
+			return nil, fmt.Errorf("unable to list container package versions for user '%s' and package '%s': %w", user, packageName, withScopeHint(err, "read:packages"))
 		}
 
 		// Add the page content to the result list.
@@ -113,22 +274,253 @@ func (gh *githubClientImpl) GetAllContainerPackageVersions(user, packageName str
 	return packageVersions, nil
 }
 
-func (gh *githubClientImpl) DeleteContainerPackageVersion(user, packageName string, id int64) error {
+func (gh *githubClientImpl) DeleteContainerPackageVersion(ctx context.Context, user, packageName string, id int64) error {
 	// Delete the package version
-	_, err := gh.client.Users.PackageDeleteVersion(gh.ctx, user, "container", packageName, id)
+	_, err := gh.client.Users.PackageDeleteVersion(ctx, user, "container", packageName, id)
 	if err != nil {
-		return fmt.Errorf("unable to delete container package version '%d' for user '%s' and package '%s': %w", id, user, packageName, err)
+		return fmt.Errorf("unable to delete container package version '%d' for user '%s' and package '%s': %w", id, user, packageName, withScopeHint(err, "read:packages, delete:packages"))
 	}
 
 	return nil
 }
 
-func (gh *githubClientImpl) GetPullRequestState(owner, repository string, id int) (string, error) {
+func (gh *githubClientImpl) GetPullRequestState(ctx context.Context, owner, repository string, id int) (string, error) {
 	// Get the pull request.
-	pr, _, err := gh.client.PullRequests.Get(gh.ctx, owner, repository, id)
+	pr, _, err := gh.repoClient.PullRequests.Get(ctx, owner, repository, id)
 	if err != nil {
-		return "", fmt.Errorf("unable to retrieve pull request for owner '%s', repository '%s', , id '%d': %w", owner, repository, id, err)
+		return "", fmt.Errorf("unable to retrieve pull request for owner '%s', repository '%s', , id '%d': %w", owner, repository, id, withScopeHint(err, "repo"))
 	}
 
 	return *pr.State, nil
 }
+
+// GetAllBranches returns all the branches of a repository
+func (gh *githubClientImpl) GetAllBranches(ctx context.Context, owner, repository string) ([]*github.Branch, error) {
+	// Create an empty list of branches.
+	var branches []*github.Branch
+
+	// List all the branches.
+	listOptions := &github.BranchListOptions{
+		ListOptions: github.ListOptions{
+			PerPage: 100,
+		},
+	}
+
+	for {
+		// Get the next page.
+		page, response, err := gh.repoClient.Repositories.ListBranches(ctx, owner, repository, listOptions)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list branches for owner '%s' and repository '%s': %w", owner, repository, withScopeHint(err, "repo"))
+		}
+
+		// Add the page content to the result list.
+		branches = append(branches, page...)
+
+		// Check if there is another page to fetch.
+		if response.NextPage == 0 {
+			break
+		}
+		listOptions.Page = response.NextPage
+	}
+
+	return branches, nil
+}
+
+// GetAllTags returns all the tags of a repository
+func (gh *githubClientImpl) GetAllTags(ctx context.Context, owner, repository string) ([]*github.RepositoryTag, error) {
+	// Create an empty list of tags.
+	var tags []*github.RepositoryTag
+
+	// List all the tags.
+	listOptions := &github.ListOptions{
+		PerPage: 100,
+	}
+
+	for {
+		// Get the next page.
+		page, response, err := gh.repoClient.Repositories.ListTags(ctx, owner, repository, listOptions)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list tags for owner '%s' and repository '%s': %w", owner, repository, withScopeHint(err, "repo"))
+		}
+
+		// Add the page content to the result list.
+		tags = append(tags, page...)
+
+		// Check if there is another page to fetch.
+		if response.NextPage == 0 {
+			break
+		}
+		listOptions.Page = response.NextPage
+	}
+
+	return tags, nil
+}
+
+// GetCommitComparisonStatus compares two commits and returns the status of the head relative to the base:
+// "identical", "ahead", "behind" or "diverged"
+func (gh *githubClientImpl) GetCommitComparisonStatus(ctx context.Context, owner, repository, base, head string) (string, error) {
+	// Compare the commits, the commit list itself is not needed so only request the smallest page.
+	comparison, _, err := gh.repoClient.Repositories.CompareCommits(ctx, owner, repository, base, head, &github.ListOptions{PerPage: 1})
+	if err != nil {
+		return "", fmt.Errorf("unable to compare commits for owner '%s', repository '%s', base '%s' and head '%s': %w", owner, repository, base, head, withScopeHint(err, "repo"))
+	}
+
+	return comparison.GetStatus(), nil
+}
+
+// GetAllPullRequestsForCommit returns all the pull requests, whatever their state, associated to a commit
+func (gh *githubClientImpl) GetAllPullRequestsForCommit(ctx context.Context, owner, repository, sha string) ([]*github.PullRequest, error) {
+	// Create an empty list of pull requests.
+	var pullRequests []*github.PullRequest
+
+	// List all the pull requests associated to the commit.
+	listOptions := &github.PullRequestListOptions{
+		State: "all",
+		ListOptions: github.ListOptions{
+			PerPage: 100,
+		},
+	}
+
+	for {
+		// Get the next page.
+		page, response, err := gh.repoClient.PullRequests.ListPullRequestsWithCommit(ctx, owner, repository, sha, listOptions)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list pull requests for owner '%s', repository '%s' and commit '%s': %w", owner, repository, sha, withScopeHint(err, "repo"))
+		}
+
+		// Add the page content to the result list.
+		pullRequests = append(pullRequests, page...)
+
+		// Check if there is another page to fetch.
+		if response.NextPage == 0 {
+			break
+		}
+		listOptions.Page = response.NextPage
+	}
+
+	return pullRequests, nil
+}
+
+// GetContainerPackage returns a package of type container
+func (gh *githubClientImpl) GetContainerPackage(ctx context.Context, user, packageName string) (*github.Package, error) {
+	pkg, _, err := gh.client.Users.GetPackage(ctx, user, "container", packageName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve container package for user '%s' and package '%s': %w", user, packageName, withScopeHint(err, "read:packages"))
+	}
+
+	return pkg, nil
+}
+
+// GetLatestContainerPackageVersion returns the latest version of a package of type container, or nil if there is none
+func (gh *githubClientImpl) GetLatestContainerPackageVersion(ctx context.Context, user, packageName string) (*github.PackageVersion, error) {
+	listOptions := &github.PackageListOptions{
+		State: github.String("active"),
+		ListOptions: github.ListOptions{
+			PerPage: 1,
+		},
+	}
+
+	pkgVersions, _, err := gh.client.Users.PackageGetAllVersions(ctx, user, "container", packageName, listOptions)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list container package versions for user '%s' and package '%s': %w", user, packageName, withScopeHint(err, "read:packages"))
+	}
+
+	if len(pkgVersions) == 0 {
+		return nil, nil
+	}
+	return pkgVersions[0], nil
+}
+
+// GetLatestPullRequest returns the latest pull request, whatever its state, of a repository, or nil if there is none
+func (gh *githubClientImpl) GetLatestPullRequest(ctx context.Context, owner, repository string) (*github.PullRequest, error) {
+	listOptions := &github.PullRequestListOptions{
+		State: "all",
+		ListOptions: github.ListOptions{
+			PerPage: 1,
+		},
+	}
+
+	prs, _, err := gh.repoClient.PullRequests.List(ctx, owner, repository, listOptions)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list pull requests for owner '%s' and repository '%s': %w", owner, repository, withScopeHint(err, "repo"))
+	}
+
+	if len(prs) == 0 {
+		return nil, nil
+	}
+	return prs[0], nil
+}
+
+// GetTokenScopes returns the OAuth scopes granted to the tokens, from the `X-OAuth-Scopes` response header
+func (gh *githubClientImpl) GetTokenScopes(ctx context.Context) (TokenScopes, error) {
+	packagesScopes, err := getTokenScopes(ctx, gh.client)
+	if err != nil {
+		return TokenScopes{}, fmt.Errorf("unable to retrieve the scopes of the packages token: %w", err)
+	}
+
+	repositoriesScopes, err := getTokenScopes(ctx, gh.repoClient)
+	if err != nil {
+		return TokenScopes{}, fmt.Errorf("unable to retrieve the scopes of the repositories token: %w", err)
+	}
+
+	return TokenScopes{
+		Packages:     packagesScopes,
+		Repositories: repositoriesScopes,
+	}, nil
+}
+
+// GetRateLimits returns the core API rate limits of the tokens
+func (gh *githubClientImpl) GetRateLimits(ctx context.Context) (RateLimits, error) {
+	packagesLimits, _, err := gh.client.RateLimits(ctx)
+	if err != nil {
+		return RateLimits{}, fmt.Errorf("unable to retrieve the rate limits of the packages token: %w", err)
+	}
+
+	repositoriesLimits, _, err := gh.repoClient.RateLimits(ctx)
+	if err != nil {
+		return RateLimits{}, fmt.Errorf("unable to retrieve the rate limits of the repositories token: %w", err)
+	}
+
+	return RateLimits{
+		Packages:     packagesLimits.GetCore(),
+		Repositories: repositoriesLimits.GetCore(),
+	}, nil
+}
+
+// getTokenScopes returns the OAuth scopes granted to the token of a client, or nil if they are unknown.
+// The rate limit endpoint is used as it is accessible to any kind of token and does not count against the rate limit.
+func getTokenScopes(ctx context.Context, client *github.Client) ([]string, error) {
+	_, response, err := client.RateLimits(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	values := response.Header.Values("X-OAuth-Scopes")
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	scopes := []string{}
+	for _, value := range values {
+		for _, scope := range strings.Split(value, ",") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	return scopes, nil
+}
+
+// withScopeHint adds a hint about the required token scopes to an error caused by a lack of permission.
+// GitHub answers with a 404 instead of a 403 for the resources the token cannot see, so both are considered.
+func withScopeHint(err error, requiredScopes string) error {
+	var errResponse *github.ErrorResponse
+	if errors.As(err, &errResponse) && errResponse.Response != nil {
+		switch errResponse.Response.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+			grantedScopes := errResponse.Response.Header.Get("X-OAuth-Scopes")
+			return fmt.Errorf("%w (the token may lack the required scope(s) '%s', granted scope(s): '%s')", err, requiredScopes, grantedScopes)
+		}
+	}
+	return err
+}
@@ -0,0 +1,197 @@
+package pkg
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/google/go-github/v49/github"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/oauth2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type GithubAppParams struct {
+	AppID      int64
+	PrivateKey []byte
+
+	// InstallationID is the identifier of the app installation, if 0 the installation of the owner is looked up.
+	InstallationID int64
+	Owner          string
+
+	// EnterpriseAPIURL is the URL of the API of the GitHub Enterprise Server, the public GitHub API is used if empty.
+	EnterpriseAPIURL string
+}
+
+// githubAppTokenSource is a token source minting GitHub App installation tokens.
+type githubAppTokenSource struct {
+	ctx            context.Context
+	client         *github.Client
+	installationID int64
+}
+
+// NewGithubAppTokenSource returns a token source minting and refreshing the installation tokens of a GitHub App.
+func NewGithubAppTokenSource(ctx context.Context, params GithubAppParams) (oauth2.TokenSource, error) {
+	// Parse the app private key.
+	key, err := parsePrivateKey(params.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a GitHub client authenticated as the app.
+	httpClient := &http.Client{
+		Transport: &githubAppTransport{
+			appID: params.AppID,
+			key:   key,
+			base:  newGithubRetryTransport(http.DefaultTransport),
+		},
+	}
+	client := github.NewClient(httpClient)
+	if params.EnterpriseAPIURL != "" {
+		client, err = newGithubEnterpriseClient(httpClient, params.EnterpriseAPIURL, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Look up the app installation if needed.
+	installationID := params.InstallationID
+	if installationID == 0 {
+		installationID, err = findInstallationID(ctx, client, params.Owner)
+		if err != nil {
+			return nil, err
+		}
+		log.Debug().Int64("installation-id", installationID).Str("owner", params.Owner).Msg("GitHub App installation found")
+	}
+
+	tokenSource := &githubAppTokenSource{
+		ctx:            ctx,
+		client:         client,
+		installationID: installationID,
+	}
+
+	// Mint the first token right away to detect any configuration error, then reuse it until it expires.
+	token, err := tokenSource.Token()
+	if err != nil {
+		return nil, err
+	}
+	return oauth2.ReuseTokenSource(token, tokenSource), nil
+}
+
+// Token mints a new installation token.
+func (ts *githubAppTokenSource) Token() (*oauth2.Token, error) {
+	installationToken, _, err := ts.client.Apps.CreateInstallationToken(ts.ctx, ts.installationID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create a token for the GitHub App installation '%d': %w", ts.installationID, err)
+	}
+
+	log.Debug().Time("expires-at", installationToken.GetExpiresAt()).Msg("GitHub App installation token created")
+
+	// Renew the token a bit before its expiration to avoid using it while it expires.
+	return &oauth2.Token{
+		AccessToken: installationToken.GetToken(),
+		TokenType:   "token",
+		Expiry:      installationToken.GetExpiresAt().Add(-time.Minute),
+	}, nil
+}
+
+// findInstallationID returns the identifier of the app installation for an organization or a user.
+func findInstallationID(ctx context.Context, client *github.Client, owner string) (int64, error) {
+	installation, _, err := client.Apps.FindOrganizationInstallation(ctx, owner)
+	if err != nil {
+		// The owner may be a user.
+		var userErr error
+		installation, _, userErr = client.Apps.FindUserInstallation(ctx, owner)
+		if userErr != nil {
+			return 0, fmt.Errorf("unable to find the GitHub App installation for owner '%s', organization: %v; user: %w", owner, err, userErr)
+		}
+	}
+
+	return installation.GetID(), nil
+}
+
+// parsePrivateKey parses a PEM encoded RSA private key, in PKCS#1 or PKCS#8 format.
+func parsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("unable to decode the GitHub App private key, it must be PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse the GitHub App private key: %w", err)
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("invalid GitHub App private key, it must be an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// githubAppTransport is an HTTP transport authenticating the requests as a GitHub App, using a JSON Web Token.
+type githubAppTransport struct {
+	appID int64
+	key   *rsa.PrivateKey
+	base  http.RoundTripper
+}
+
+func (t *githubAppTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	jwt, err := t.createJWT(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	// Add the authorization header on a copy of the request, as a round tripper must not modify it.
+	authReq := req.Clone(req.Context())
+	authReq.Header.Set("Authorization", "Bearer "+jwt)
+
+	return t.base.RoundTrip(authReq)
+}
+
+// createJWT creates a JSON Web Token signed with the app private key.
+func (t *githubAppTransport) createJWT(now time.Time) (string, error) {
+	header := map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+	}
+
+	// Issue the token in the past to allow for clock drift, GitHub accepts tokens valid for 10 minutes at most.
+	claims := map[string]interface{}{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": strconv.FormatInt(t.appID, 10),
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", fmt.Errorf("unable to serialize the JWT header: %w", err)
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("unable to serialize the JWT claims: %w", err)
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, t.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", fmt.Errorf("unable to sign the JWT: %w", err)
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
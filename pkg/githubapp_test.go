@@ -0,0 +1,119 @@
+package pkg
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/google/go-github/v49/github"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+//
+// Test suite definition.
+//
+
+type GithubAppTestSuite struct {
+	suite.Suite
+}
+
+func TestGithubAppTestSuite(t *testing.T) {
+	suite.Run(t, new(GithubAppTestSuite))
+}
+
+//
+// Tests.
+//
+
+func (s *GithubAppTestSuite) TestParsePrivateKey() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	s.Require().NoError(err)
+
+	// PKCS#1 format.
+	pkcs1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	parsed, err := parsePrivateKey(pkcs1)
+	s.Require().NoError(err)
+	s.Require().True(key.Equal(parsed))
+
+	// PKCS#8 format.
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	s.Require().NoError(err)
+	pkcs8 := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	parsed, err = parsePrivateKey(pkcs8)
+	s.Require().NoError(err)
+	s.Require().True(key.Equal(parsed))
+
+	// Invalid key.
+	_, err = parsePrivateKey([]byte("not a key"))
+	s.Require().Error(err)
+}
+
+func (s *GithubAppTestSuite) TestCreateJWT() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	s.Require().NoError(err)
+
+	transport := &githubAppTransport{appID: 1234, key: key}
+	now := time.Unix(1700000000, 0)
+	jwt, err := transport.createJWT(now)
+
+	r := s.Require()
+	r.NoError(err)
+
+	parts := strings.Split(jwt, ".")
+	r.Len(parts, 3)
+
+	// Check the claims.
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	r.NoError(err)
+	var claims map[string]interface{}
+	r.NoError(json.Unmarshal(claimsJSON, &claims))
+	r.Equal("1234", claims["iss"])
+	r.Equal(float64(now.Add(-time.Minute).Unix()), claims["iat"])
+	r.Equal(float64(now.Add(9*time.Minute).Unix()), claims["exp"])
+
+	// Check the signature.
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	r.NoError(err)
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r.NoError(rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hash[:], signature))
+}
+
+func (s *GithubAppTestSuite) TestFindInstallationIDErrors() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/orgs/owner/installation", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"message": "organization not found"}`))
+	})
+	mux.HandleFunc("/users/owner/installation", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"message": "user not found"}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	r := s.Require()
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(server.URL + "/")
+	r.NoError(err)
+	client.BaseURL = baseURL
+
+	// Both lookups are reported.
+	_, err = findInstallationID(context.Background(), client, "owner")
+	r.ErrorContains(err, "organization not found")
+	r.ErrorContains(err, "user not found")
+
+	var errorResponse *github.ErrorResponse
+	r.ErrorAs(err, &errorResponse)
+	r.Equal("user not found", errorResponse.Message)
+}
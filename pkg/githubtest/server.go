@@ -0,0 +1,335 @@
+// Package githubtest provides a fake of the GitHub packages and pull requests REST API, to test the code using the
+// GitHub client without calling GitHub.
+package githubtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/go-github/v49/github"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is a stateful fake of the GitHub packages and pull requests REST API, serving the container packages, package
+// versions and pull requests it is seeded with. The deletions of package versions are applied to its state.
+type Server struct {
+	*httptest.Server
+
+	mu sync.Mutex
+
+	// The versions of the packages, by user/package.
+	versionsByPackage map[string][]*github.PackageVersion
+	nextVersionID     int64
+
+	// The IDs of the deleted package versions, by user/package.
+	deletedVersionsByPackage map[string][]int64
+
+	// The pull requests, by owner/repository.
+	pullRequestsByRepository map[string][]*github.PullRequest
+
+	// The status returned when deleting a package version, by hash.
+	deletionStatusByHash map[string]int
+}
+
+// NewServer starts and returns a new fake GitHub API server, the caller should call Close when finished to shut it
+// down.
+func NewServer() *Server {
+	s := &Server{
+		versionsByPackage:        make(map[string][]*github.PackageVersion),
+		nextVersionID:            1,
+		deletedVersionsByPackage: make(map[string][]int64),
+		pullRequestsByRepository: make(map[string][]*github.PullRequest),
+		deletionStatusByHash:     make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// BaseURL returns the base URL of the server, to be used as the base URL of a github.Client. The server also serves the
+// API under the `/api/v3` path, as a GitHub Enterprise Server does.
+func (s *Server) BaseURL() string {
+	return s.URL + "/"
+}
+
+// AddPackage adds a container package without any version, if it does not already exist.
+func (s *Server) AddPackage(user, packageName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := user + "/" + packageName
+	if _, found := s.versionsByPackage[key]; !found {
+		s.versionsByPackage[key] = []*github.PackageVersion{}
+	}
+}
+
+// AddVersion adds a version to a container package, creating the package if needed, and returns its ID. The name of
+// the version is the hash of the registry object.
+func (s *Server) AddVersion(user, packageName, hash string, tags ...string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.nextVersionID
+	s.nextVersionID++
+
+	now := github.Timestamp{Time: time.Now()}
+	key := user + "/" + packageName
+	s.versionsByPackage[key] = append(s.versionsByPackage[key], &github.PackageVersion{
+		ID:        github.Int64(id),
+		Name:      github.String(hash),
+		CreatedAt: &now,
+		UpdatedAt: &now,
+		Metadata: &github.PackageMetadata{
+			PackageType: github.String("container"),
+			Container:   &github.PackageContainerMetadata{Tags: tags},
+		},
+	})
+
+	return id
+}
+
+// SetTags replaces the tags of a package version.
+func (s *Server) SetTags(user, packageName, hash string, tags ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, version := range s.versionsByPackage[user+"/"+packageName] {
+		if version.GetName() == hash {
+			version.Metadata.Container.Tags = tags
+		}
+	}
+}
+
+// AddPullRequest adds a pull request to a repository, or updates its state if it already exists.
+func (s *Server) AddPullRequest(owner, repository string, number int, state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := owner + "/" + repository
+	for _, pr := range s.pullRequestsByRepository[key] {
+		if pr.GetNumber() == number {
+			pr.State = github.String(state)
+			return
+		}
+	}
+
+	s.pullRequestsByRepository[key] = append(s.pullRequestsByRepository[key], &github.PullRequest{
+		Number: github.Int(number),
+		State:  github.String(state),
+	})
+}
+
+// FailDeletion makes the deletion of a package version fail with an HTTP status.
+func (s *Server) FailDeletion(hash string, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deletionStatusByHash[hash] = status
+}
+
+// Versions returns the hashes of the versions of a container package that have not been deleted.
+func (s *Server) Versions(user, packageName string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hashes := []string{}
+	for _, version := range s.versionsByPackage[user+"/"+packageName] {
+		hashes = append(hashes, version.GetName())
+	}
+	return hashes
+}
+
+// DeletedVersionIDs returns the IDs of the deleted versions of a container package, in the order of their deletion.
+func (s *Server) DeletedVersionIDs(user, packageName string) []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]int64{}, s.deletedVersionsByPackage[user+"/"+packageName]...)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The API of a GitHub Enterprise Server is served under the `/api/v3` path.
+	path := strings.TrimPrefix(r.URL.Path, "/api/v3")
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	isPackagesPath := len(parts) >= 3 && parts[0] == "users" && parts[2] == "packages"
+	isPullsPath := len(parts) >= 4 && parts[0] == "repos" && parts[3] == "pulls"
+
+	switch {
+	case len(parts) == 1 && parts[0] == "rate_limit" && r.Method == http.MethodGet:
+		s.getRateLimits(w)
+
+	case isPackagesPath && len(parts) == 3 && r.Method == http.MethodGet:
+		s.listPackages(w, r, parts[1])
+
+	case isPackagesPath && len(parts) == 5 && r.Method == http.MethodGet:
+		s.getPackage(w, parts[1], parts[3], parts[4])
+
+	case isPackagesPath && len(parts) == 6 && parts[5] == "versions" && r.Method == http.MethodGet:
+		s.listVersions(w, r, parts[1], parts[3], parts[4])
+
+	case isPackagesPath && len(parts) == 7 && parts[5] == "versions" && r.Method == http.MethodDelete:
+		s.deleteVersion(w, parts[1], parts[3], parts[4], parts[6])
+
+	case isPullsPath && len(parts) == 4 && r.Method == http.MethodGet:
+		s.listPullRequests(w, r, parts[1], parts[2])
+
+	case isPullsPath && len(parts) == 5 && r.Method == http.MethodGet:
+		s.getPullRequest(w, parts[1], parts[2], parts[4])
+
+	default:
+		writeError(w, http.StatusNotFound, "Not Found")
+	}
+}
+
+func (s *Server) getRateLimits(w http.ResponseWriter) {
+	rate := &github.Rate{Limit: 5000, Remaining: 5000, Reset: github.Timestamp{Time: time.Now().Add(time.Hour)}}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"resources": &github.RateLimits{Core: rate},
+		"rate":      rate,
+	})
+}
+
+func (s *Server) listPackages(w http.ResponseWriter, r *http.Request, user string) {
+	var packages []*github.Package
+	for key := range s.versionsByPackage {
+		packageUser, packageName, _ := strings.Cut(key, "/")
+		if packageUser == user {
+			packages = append(packages, newPackage(packageName))
+		}
+	}
+	sort.Slice(packages, func(i, j int) bool {
+		return packages[i].GetName() < packages[j].GetName()
+	})
+
+	writePage(w, r, packages)
+}
+
+func (s *Server) getPackage(w http.ResponseWriter, user, packageType, packageName string) {
+	if _, found := s.versionsByPackage[user+"/"+packageName]; !found || packageType != "container" {
+		writeError(w, http.StatusNotFound, "Package not found.")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newPackage(packageName))
+}
+
+func (s *Server) listVersions(w http.ResponseWriter, r *http.Request, user, packageType, packageName string) {
+	versions, found := s.versionsByPackage[user+"/"+packageName]
+	if !found || packageType != "container" {
+		writeError(w, http.StatusNotFound, "Package not found.")
+		return
+	}
+
+	// List the most recent versions first, as GitHub does.
+	sorted := make([]*github.PackageVersion, 0, len(versions))
+	for i := len(versions) - 1; i >= 0; i-- {
+		sorted = append(sorted, versions[i])
+	}
+
+	writePage(w, r, sorted)
+}
+
+func (s *Server) deleteVersion(w http.ResponseWriter, user, packageType, packageName, versionID string) {
+	key := user + "/" + packageName
+	id, _ := strconv.ParseInt(versionID, 10, 64)
+	for i, version := range s.versionsByPackage[key] {
+		if version.GetID() != id || packageType != "container" {
+			continue
+		}
+
+		if status, found := s.deletionStatusByHash[version.GetName()]; found {
+			writeError(w, status, http.StatusText(status))
+			return
+		}
+
+		s.versionsByPackage[key] = append(s.versionsByPackage[key][:i], s.versionsByPackage[key][i+1:]...)
+		s.deletedVersionsByPackage[key] = append(s.deletedVersionsByPackage[key], id)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writeError(w, http.StatusNotFound, "Package version not found.")
+}
+
+func (s *Server) listPullRequests(w http.ResponseWriter, r *http.Request, owner, repository string) {
+	// List the most recent pull requests first, as GitHub does by default.
+	pullRequests := append([]*github.PullRequest{}, s.pullRequestsByRepository[owner+"/"+repository]...)
+	sort.Slice(pullRequests, func(i, j int) bool {
+		return pullRequests[i].GetNumber() > pullRequests[j].GetNumber()
+	})
+
+	writePage(w, r, pullRequests)
+}
+
+func (s *Server) getPullRequest(w http.ResponseWriter, owner, repository, number string) {
+	n, _ := strconv.Atoi(number)
+	for _, pr := range s.pullRequestsByRepository[owner+"/"+repository] {
+		if pr.GetNumber() == n {
+			writeJSON(w, http.StatusOK, pr)
+			return
+		}
+	}
+
+	writeError(w, http.StatusNotFound, "Not Found")
+}
+
+// newPackage returns a container package.
+func newPackage(name string) *github.Package {
+	return &github.Package{
+		Name:        github.String(name),
+		PackageType: github.String("container"),
+	}
+}
+
+// writePage writes the page of a list requested by the `page` and `per_page` query parameters, along with the link to
+// the next page if any.
+func writePage[T any](w http.ResponseWriter, r *http.Request, items []T) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil || perPage < 1 {
+		perPage = 30
+	}
+
+	start := (page - 1) * perPage
+	if start > len(items) {
+		start = len(items)
+	}
+	end := start + perPage
+	if end > len(items) {
+		end = len(items)
+	}
+
+	if end < len(items) {
+		next := *r.URL
+		next.Scheme = "http"
+		next.Host = r.Host
+		query := next.Query()
+		query.Set("page", strconv.Itoa(page+1))
+		next.RawQuery = query.Encode()
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.String()))
+	}
+
+	writeJSON(w, http.StatusOK, items[start:end])
+}
+
+// writeError writes a JSON error response, as GitHub does.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"message": message})
+}
+
+// writeJSON writes a JSON response.
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,142 @@
+package githubtest_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/pcasteran/ghcr-cleaning-action/pkg"
+	"github.com/pcasteran/ghcr-cleaning-action/pkg/githubtest"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"testing"
+)
+
+//
+// Test suite definition.
+//
+
+type ServerTestSuite struct {
+	suite.Suite
+
+	server   *githubtest.Server
+	ghClient pkg.GithubClient
+}
+
+func TestServerTestSuite(t *testing.T) {
+	suite.Run(t, new(ServerTestSuite))
+}
+
+func (s *ServerTestSuite) SetupTest() {
+	s.server = githubtest.NewServer()
+
+	var err error
+	s.ghClient, err = pkg.NewGithubClient(context.Background(), "token", pkg.WithGithubBaseURL(s.server.BaseURL()))
+	s.Require().NoError(err)
+}
+
+func (s *ServerTestSuite) TearDownTest() {
+	s.server.Close()
+}
+
+//
+// Tests.
+//
+
+func (s *ServerTestSuite) TestPackages() {
+	s.server.AddPackage("user", "empty")
+	s.server.AddVersion("user", "package", "sha256:1")
+
+	// List the packages.
+	r := s.Require()
+	packages, err := s.ghClient.GetAllContainerPackages(context.Background(), "user")
+	r.NoError(err)
+	r.Len(packages, 2)
+	r.Equal("empty", packages[0].GetName())
+	r.Equal("package", packages[1].GetName())
+
+	// Get a package.
+	_, err = s.ghClient.GetContainerPackage(context.Background(), "user", "package")
+	r.NoError(err)
+	_, err = s.ghClient.GetContainerPackage(context.Background(), "user", "unknown")
+	r.Error(err)
+}
+
+func (s *ServerTestSuite) TestVersions() {
+	// Create more versions than the size of a page.
+	var hashes []string
+	for i := 0; i < 150; i++ {
+		hash := fmt.Sprintf("sha256:%d", i)
+		s.server.AddVersion("user", "package", hash, fmt.Sprintf("tag-%d", i))
+		hashes = append(hashes, hash)
+	}
+	s.server.SetTags("user", "package", "sha256:0", "latest", "v1")
+
+	// List all the versions.
+	r := s.Require()
+	versions, err := s.ghClient.GetAllContainerPackageVersions(context.Background(), "user", "package")
+	r.NoError(err)
+	r.Len(versions, 150)
+	r.Equal("sha256:149", versions[0].GetName())
+	r.Equal([]string{"latest", "v1"}, versions[149].GetMetadata().GetContainer().Tags)
+
+	// Get the latest version.
+	latest, err := s.ghClient.GetLatestContainerPackageVersion(context.Background(), "user", "package")
+	r.NoError(err)
+	r.Equal("sha256:149", latest.GetName())
+}
+
+func (s *ServerTestSuite) TestDeleteVersion() {
+	id := s.server.AddVersion("user", "package", "sha256:1")
+	failingID := s.server.AddVersion("user", "package", "sha256:2")
+	s.server.FailDeletion("sha256:2", http.StatusForbidden)
+
+	// Delete the versions.
+	r := s.Require()
+	r.NoError(s.ghClient.DeleteContainerPackageVersion(context.Background(), "user", "package", id))
+	r.Error(s.ghClient.DeleteContainerPackageVersion(context.Background(), "user", "package", failingID))
+	r.Error(s.ghClient.DeleteContainerPackageVersion(context.Background(), "user", "package", id))
+
+	// Check the state of the server.
+	r.Equal([]string{"sha256:2"}, s.server.Versions("user", "package"))
+	r.Equal([]int64{id}, s.server.DeletedVersionIDs("user", "package"))
+}
+
+func (s *ServerTestSuite) TestPullRequests() {
+	s.server.AddPullRequest("owner", "repository", 1, "closed")
+	s.server.AddPullRequest("owner", "repository", 2, "open")
+	s.server.AddPullRequest("owner", "repository", 1, "open")
+
+	// Get the state of the pull requests.
+	r := s.Require()
+	state, err := s.ghClient.GetPullRequestState(context.Background(), "owner", "repository", 1)
+	r.NoError(err)
+	r.Equal("open", state)
+	_, err = s.ghClient.GetPullRequestState(context.Background(), "owner", "repository", 3)
+	r.Error(err)
+
+	// Get the latest pull request.
+	latest, err := s.ghClient.GetLatestPullRequest(context.Background(), "owner", "repository")
+	r.NoError(err)
+	r.Equal(2, latest.GetNumber())
+}
+
+func (s *ServerTestSuite) TestRateLimits() {
+	limits, err := s.ghClient.GetRateLimits(context.Background())
+
+	r := s.Require()
+	r.NoError(err)
+	r.Equal(5000, limits.Packages.Remaining)
+	r.Equal(5000, limits.Repositories.Remaining)
+}
+
+func (s *ServerTestSuite) TestEnterpriseAPI() {
+	s.server.AddVersion("user", "package", "sha256:1")
+
+	// Target the server as a GitHub Enterprise Server.
+	r := s.Require()
+	ghClient, err := pkg.NewGithubClient(context.Background(), "token", pkg.WithGithubEnterpriseURLs(s.server.URL, ""))
+	r.NoError(err)
+
+	versions, err := ghClient.GetAllContainerPackageVersions(context.Background(), "user", "package")
+	r.NoError(err)
+	r.Len(versions, 1)
+}
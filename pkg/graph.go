@@ -0,0 +1,104 @@
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The colors of the graph nodes, by decision.
+const (
+	keepColor   = "#d4edda"
+	deleteColor = "#f8d7da"
+)
+
+// WriteDOT writes the reference graph of the plan in Graphviz DOT format: a node per package version, labelled with its
+// short digest and its tags and colored by decision, a solid edge from each image index to its manifests and a dashed
+// one from each referrer to its subject.
+func (p *Plan) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph package {\n")
+	b.WriteString("  node [shape=box, style=filled, fontname=monospace];\n")
+
+	for _, decision := range p.Decisions {
+		color := keepColor
+		if decision.Delete {
+			color = deleteColor
+		}
+		fmt.Fprintf(&b, "  %q [label=%q, fillcolor=%q];\n", decision.Hash, getNodeLabel(decision, "\n"), color)
+	}
+
+	for _, decision := range p.Decisions {
+		for _, manifest := range decision.Manifests {
+			fmt.Fprintf(&b, "  %q -> %q;\n", decision.Hash, manifest)
+		}
+		if decision.Subject != "" {
+			fmt.Fprintf(&b, "  %q -> %q [style=dashed, label=\"subject\"];\n", decision.Hash, decision.Subject)
+		}
+	}
+
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteMermaid writes the reference graph of the plan as a Mermaid flowchart, with the same nodes and edges as
+// WriteDOT.
+func (p *Plan) WriteMermaid(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("flowchart TD\n")
+
+	// The digests cannot be used as node ids, the nodes are identified by their position in the plan.
+	idByHash := make(map[string]string)
+	var kept, deleted []string
+	for i, decision := range p.Decisions {
+		id := fmt.Sprintf("n%d", i)
+		idByHash[decision.Hash] = id
+		if decision.Delete {
+			deleted = append(deleted, id)
+		} else {
+			kept = append(kept, id)
+		}
+		fmt.Fprintf(&b, "  %s[\"%s\"]\n", id, getNodeLabel(decision, "<br/>"))
+	}
+
+	for _, decision := range p.Decisions {
+		for _, manifest := range decision.Manifests {
+			fmt.Fprintf(&b, "  %s --> %s\n", idByHash[decision.Hash], idByHash[manifest])
+		}
+		if decision.Subject != "" {
+			fmt.Fprintf(&b, "  %s -. subject .-> %s\n", idByHash[decision.Hash], idByHash[decision.Subject])
+		}
+	}
+
+	fmt.Fprintf(&b, "  classDef keep fill:%s\n", keepColor)
+	fmt.Fprintf(&b, "  classDef delete fill:%s\n", deleteColor)
+	if len(kept) > 0 {
+		fmt.Fprintf(&b, "  class %s keep\n", strings.Join(kept, ","))
+	}
+	if len(deleted) > 0 {
+		fmt.Fprintf(&b, "  class %s delete\n", strings.Join(deleted, ","))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// getNodeLabel returns the label of the node of a package version: its short digest and its tags, separated by a line
+// break.
+func getNodeLabel(decision *Decision, lineBreak string) string {
+	label := ShortDigest(decision.Hash)
+	if len(decision.Tags) > 0 {
+		label += lineBreak + strings.Join(decision.Tags, ", ")
+	}
+	return label
+}
+
+// ShortDigest returns a digest whose hex part is truncated to 12 characters, like the Docker CLI does.
+func ShortDigest(digest string) string {
+	algorithm, hex, found := strings.Cut(digest, ":")
+	if !found || len(hex) <= 12 {
+		return digest
+	}
+	return algorithm + ":" + hex[:12]
+}
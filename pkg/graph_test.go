@@ -0,0 +1,400 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/go-github/v49/github"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+//
+// Test suite definition.
+//
+
+type GraphTestSuite struct {
+	suite.Suite
+}
+
+func TestGraphTestSuite(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	suite.Run(t, new(GraphTestSuite))
+}
+
+//
+// Random package graphs.
+//
+
+// The number of random graphs checked by the property tests.
+const nRandomGraphs = 300
+
+// randomGraph is a random package made of images and image indices, the indices referencing the previous items so that
+// the graph is acyclic, and the children being shared between the indices.
+type randomGraph struct {
+	items map[string]TestDataItem
+
+	// The state of the pull requests referenced by the tags, the unknown pull requests cannot be retrieved.
+	prStates map[int]string
+}
+
+// newRandomGraph generates a random package graph.
+func newRandomGraph(rnd *rand.Rand) *randomGraph {
+	g := &randomGraph{
+		items:    make(map[string]TestDataItem),
+		prStates: make(map[int]string),
+	}
+
+	// Pull requests 1 to 4 exist, 5 does not.
+	for id := 1; id <= 4; id++ {
+		g.prStates[id] = []string{"open", "closed"}[rnd.Intn(2)]
+	}
+
+	nImages := 1 + rnd.Intn(20)
+	nIndices := rnd.Intn(12)
+	for i := 0; i < nImages+nIndices; i++ {
+		item := TestDataItem{}
+
+		// Add up to 2 tags, either a valid one or a pull request one.
+		for j := rnd.Intn(3); j > 0; j-- {
+			if rnd.Intn(3) == 0 {
+				item.tags = append(item.tags, fmt.Sprintf("v%d", rnd.Intn(100)))
+			} else {
+				item.tags = append(item.tags, fmt.Sprintf("pr-%d", 1+rnd.Intn(5)))
+			}
+		}
+
+		// An index references a random subset of the previous items.
+		if i >= nImages {
+			nChildren := 1 + rnd.Intn(4)
+			if nChildren > i {
+				nChildren = i
+			}
+			for _, child := range rnd.Perm(i)[:nChildren] {
+				item.references = append(item.references, randomGraphHash(child))
+			}
+		}
+
+		g.items[randomGraphHash(i)] = item
+	}
+
+	return g
+}
+
+// randomGraphHash returns the hash of the i-th item of a random graph.
+func randomGraphHash(i int) string {
+	return fmt.Sprintf("sha256:%064x", i+1)
+}
+
+// shuffled returns a copy of the graph items whose references are shuffled.
+func (g *randomGraph) shuffled(rnd *rand.Rand) map[string]TestDataItem {
+	items := make(map[string]TestDataItem)
+	for hash, item := range g.items {
+		references := append([]string{}, item.references...)
+		rnd.Shuffle(len(references), func(i, j int) {
+			references[i], references[j] = references[j], references[i]
+		})
+		items[hash] = TestDataItem{tags: item.tags, references: references}
+	}
+	return items
+}
+
+// hasValidTags returns whether an item has a tag which is not related to a closed pull request, an unknown pull
+// request being considered as valid as its state cannot be checked.
+func (g *randomGraph) hasValidTags(hash string) bool {
+	for _, tag := range g.items[hash].tags {
+		id, err := strconv.Atoi(strings.TrimPrefix(tag, "pr-"))
+		if err != nil || g.prStates[id] != "closed" {
+			return true
+		}
+	}
+	return false
+}
+
+// computePlan computes the plan of the graph items.
+func (g *randomGraph) computePlan(r *require.Assertions, items map[string]TestDataItem) *Plan {
+	versions, images, indices := buildTestData(items)
+	ghClient := &prStateGithubClient{states: g.prStates}
+
+	plan, err := computePlan(context.Background(), ghClient, defaultPrFilterParams, CommitFilterParams{}, LabelFilterParams{}, versions, images, indices)
+	r.NoError(err)
+	return plan
+}
+
+// checkInvariants checks the invariants of the plan of the graph.
+func (g *randomGraph) checkInvariants(r *require.Assertions, plan *Plan) {
+	// There is exactly one decision per item.
+	decisionByHash := make(map[string]*Decision)
+	positionByHash := make(map[string]int)
+	for i, decision := range plan.Decisions {
+		r.NotContains(decisionByHash, decision.Hash)
+		decisionByHash[decision.Hash] = decision
+		positionByHash[decision.Hash] = i
+	}
+	r.Len(decisionByHash, len(g.items))
+
+	// Compute the items to keep: the ones having valid tags and, transitively, the items they reference.
+	kept := make(map[string]bool)
+	var keep func(hash string)
+	keep = func(hash string) {
+		if kept[hash] {
+			return
+		}
+		kept[hash] = true
+		for _, child := range g.items[hash].references {
+			keep(child)
+		}
+	}
+	for hash := range g.items {
+		if g.hasValidTags(hash) {
+			keep(hash)
+		}
+	}
+
+	for hash, item := range g.items {
+		decision := decisionByHash[hash]
+
+		// An item having valid tags is never deleted.
+		if g.hasValidTags(hash) {
+			r.False(decision.Delete, "item %s with valid tags %v deleted", hash, item.tags)
+		}
+
+		// A kept index never loses a child.
+		if !decision.Delete {
+			for _, child := range item.references {
+				r.False(decisionByHash[child].Delete, "child %s of kept index %s deleted", child, hash)
+			}
+		}
+
+		// The decision of a child without valid tags derives from the ones of its parents.
+		for _, child := range item.references {
+			if !g.hasValidTags(child) {
+				r.NotEmpty(decisionByHash[child].Provenance, "no provenance for child %s", child)
+			}
+		}
+
+		// An index comes before its children in the plan, so it is deleted first.
+		for _, child := range item.references {
+			r.Less(positionByHash[hash], positionByHash[child], "index %s after its child %s", hash, child)
+		}
+
+		// The items are deleted if and only if they are not reachable from an item having valid tags.
+		r.Equal(!kept[hash], decision.Delete, "unexpected decision for item %s: %s", hash, decision.Reason)
+	}
+}
+
+// prStateGithubClient is a GitHub client returning the states of the pull requests from a map, failing for the unknown
+// ones.
+type prStateGithubClient struct {
+	githubClientMock
+	states map[int]string
+}
+
+func (c *prStateGithubClient) GetPullRequestState(_ context.Context, _, _ string, id int) (string, error) {
+	state, found := c.states[id]
+	if !found {
+		return "", &github.ErrorResponse{Message: "Not Found"}
+	}
+	return state, nil
+}
+
+//
+// Property tests.
+//
+
+func (s *GraphTestSuite) TestComputePlanInvariants() {
+	r := s.Require()
+	for seed := int64(0); seed < nRandomGraphs; seed++ {
+		g := newRandomGraph(rand.New(rand.NewSource(seed)))
+
+		plan := g.computePlan(r, g.items)
+		g.checkInvariants(r, plan)
+	}
+}
+
+func (s *GraphTestSuite) TestComputePlanOrderIndependence() {
+	r := s.Require()
+	for seed := int64(0); seed < nRandomGraphs; seed++ {
+		rnd := rand.New(rand.NewSource(seed))
+		g := newRandomGraph(rnd)
+
+		// The plan must not depend on the order of the references, nor on the iteration order of the maps.
+		plan := g.computePlan(r, g.items)
+		for i := 0; i < 3; i++ {
+			r.Equal(plan, g.computePlan(r, g.shuffled(rnd)), "plans differ for seed %d", seed)
+		}
+	}
+}
+
+//
+// Fuzz tests.
+//
+
+func FuzzComputePlan(f *testing.F) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	for seed := int64(0); seed < 10; seed++ {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, seed int64) {
+		r := require.New(t)
+		g := newRandomGraph(rand.New(rand.NewSource(seed)))
+
+		g.checkInvariants(r, g.computePlan(r, g.items))
+	})
+}
+
+// The grammar of the OCI tags.
+var ociTagRegex = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+
+func FuzzDefaultPrTagRegex(f *testing.F) {
+	for _, tag := range []string{"pr-1", "pr-1234-linux", "pr-", "pr-x", "v1.0.0", "pr-99999999999999999999", "PR-1"} {
+		f.Add(tag)
+	}
+
+	f.Fuzz(func(t *testing.T, tag string) {
+		r := require.New(t)
+		regex, matches := matchPullRequestTag(defaultPrFilterParams, tag)
+
+		// Only the tags starting with `pr-` and a digit are pull request tags.
+		isPrTag := strings.HasPrefix(tag, "pr-") && len(tag) > 3 && tag[3] >= '0' && tag[3] <= '9'
+		r.Equal(isPrTag, matches != nil)
+		if !isPrTag {
+			return
+		}
+
+		// The pull request id is made of the leading digits.
+		digits := strings.TrimPrefix(tag, "pr-")
+		if i := strings.IndexFunc(digits, func(c rune) bool { return c < '0' || c > '9' }); i >= 0 {
+			digits = digits[:i]
+		}
+
+		_, _, id, err := getPullRequestReference(defaultPrFilterParams, regex, matches)
+		expectedID, expectedErr := strconv.Atoi(digits)
+		if expectedErr != nil {
+			r.Error(err)
+			return
+		}
+		r.NoError(err)
+		r.Equal(expectedID, id)
+	})
+}
+
+func FuzzPrTagRegex(f *testing.F) {
+	f.Add(DefaultPrTagPattern, "pr-1")
+	f.Add(`^(?P<repo>[a-z]+)-pr-(?P<id>\d+)$`, "api-pr-12")
+	f.Add(`^(?P<repo>[a-z]*)-?pr-(?P<id>\d+)$`, "pr-12")
+	f.Add(`^(?P<repo>[a-z]+)-pr-(?P<id>\d+)$`, "other-pr-3")
+	f.Add(`^(?P<repo>[a-z]+)-pr-(?P<id>\d+)$`, "invalid-pr-3")
+	f.Add(`^pr-(?P<id>[0-9a-z]+)$`, "pr-abc")
+	f.Add(`^(?P<repo>.+)$`, "pr-1")
+
+	prFilterParams := PullRequestFilterParams{
+		Owner:      "owner",
+		Repository: "repository",
+		RepositoryByName: map[string]string{
+			"other":   "other-owner/other-repository",
+			"invalid": "invalid",
+		},
+	}
+
+	f.Fuzz(func(t *testing.T, pattern, tag string) {
+		regex, err := NewPrTagRegex(pattern)
+		if err != nil || !ociTagRegex.MatchString(tag) {
+			return
+		}
+
+		// A valid pull request tag regex never makes the lookup of the pull request panic.
+		params := prFilterParams
+		params.TagRegexes = []*regexp.Regexp{regex}
+		matchedRegex, matches := matchPullRequestTag(params, tag)
+		if matches == nil {
+			return
+		}
+
+		owner, repository, _, err := getPullRequestReference(params, matchedRegex, matches)
+		if err != nil {
+			return
+		}
+
+		// The pull request is looked up in a well-formed repository.
+		r := require.New(t)
+		r.NotEmpty(owner)
+		r.NotEmpty(repository)
+		r.NotContains(owner, "/")
+		r.NotContains(repository, "/")
+	})
+}
+
+//
+// Rendering tests.
+//
+
+// The plan of an index whose manifests are kept, along with an untagged index and a signature of the first image.
+var renderedPlan = &Plan{Decisions: []*Decision{
+	{Hash: "sha256:aaaaaaaaaaaaaaaaaaaa", Tags: []string{"v1", "latest"}, Reason: "valid tags", Manifests: []string{"sha256:cccccccccccccccccccc"}},
+	{Hash: "sha256:bbbbbbbbbbbbbbbbbbbb", Delete: true, Reason: "untagged", Manifests: []string{"sha256:cccccccccccccccccccc", "sha256:dddddddddddddddddddd"}},
+	{Hash: "sha256:eeeeeeeeeeeeeeeeeeee", Delete: true, Reason: "untagged", Subject: "sha256:cccccccccccccccccccc"},
+	{Hash: "sha256:cccccccccccccccccccc", Reason: "referenced by a kept image index"},
+	{Hash: "sha256:dddddddddddddddddddd", Delete: true, Reason: "untagged"},
+}}
+
+func (s *GraphTestSuite) TestWriteDOT() {
+	var out strings.Builder
+	r := s.Require()
+	r.NoError(renderedPlan.WriteDOT(&out))
+	r.Equal(`digraph package {
+  node [shape=box, style=filled, fontname=monospace];
+  "sha256:aaaaaaaaaaaaaaaaaaaa" [label="sha256:aaaaaaaaaaaa\nv1, latest", fillcolor="#d4edda"];
+  "sha256:bbbbbbbbbbbbbbbbbbbb" [label="sha256:bbbbbbbbbbbb", fillcolor="#f8d7da"];
+  "sha256:eeeeeeeeeeeeeeeeeeee" [label="sha256:eeeeeeeeeeee", fillcolor="#f8d7da"];
+  "sha256:cccccccccccccccccccc" [label="sha256:cccccccccccc", fillcolor="#d4edda"];
+  "sha256:dddddddddddddddddddd" [label="sha256:dddddddddddd", fillcolor="#f8d7da"];
+  "sha256:aaaaaaaaaaaaaaaaaaaa" -> "sha256:cccccccccccccccccccc";
+  "sha256:bbbbbbbbbbbbbbbbbbbb" -> "sha256:cccccccccccccccccccc";
+  "sha256:bbbbbbbbbbbbbbbbbbbb" -> "sha256:dddddddddddddddddddd";
+  "sha256:eeeeeeeeeeeeeeeeeeee" -> "sha256:cccccccccccccccccccc" [style=dashed, label="subject"];
+}
+`, out.String())
+}
+
+func (s *GraphTestSuite) TestWriteMermaid() {
+	var out strings.Builder
+	r := s.Require()
+	r.NoError(renderedPlan.WriteMermaid(&out))
+	r.Equal(`flowchart TD
+  n0["sha256:aaaaaaaaaaaa<br/>v1, latest"]
+  n1["sha256:bbbbbbbbbbbb"]
+  n2["sha256:eeeeeeeeeeee"]
+  n3["sha256:cccccccccccc"]
+  n4["sha256:dddddddddddd"]
+  n0 --> n3
+  n1 --> n3
+  n1 --> n4
+  n2 -. subject .-> n3
+  classDef keep fill:#d4edda
+  classDef delete fill:#f8d7da
+  class n0,n3 keep
+  class n1,n2,n4 delete
+`, out.String())
+}
+
+func (s *GraphTestSuite) TestComputePlanGraph() {
+	r := s.Require()
+	for seed := int64(0); seed < nRandomGraphs; seed++ {
+		g := newRandomGraph(rand.New(rand.NewSource(seed)))
+
+		// The plan describes the references of the graph.
+		for _, decision := range g.computePlan(r, g.items).Decisions {
+			r.ElementsMatch(g.items[decision.Hash].references, decision.Manifests)
+			r.Empty(decision.Subject)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/rs/zerolog/log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The kinds of registry objects.
+const (
+	ImageKind       = "image"
+	IndexKind       = "index"
+	SignatureKind   = "signature"
+	AttestationKind = "attestation"
+
+	// UnknownKind is the kind of the package versions whose registry object could not be retrieved.
+	UnknownKind = "unknown"
+)
+
+// InventoryItem describes a package version and its registry object.
+type InventoryItem struct {
+	Digest    string     `json:"digest"`
+	VersionID int64      `json:"versionId,omitempty"`
+	Tags      []string   `json:"tags,omitempty"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+
+	// Kind is either ImageKind, IndexKind, SignatureKind, AttestationKind or UnknownKind.
+	Kind string `json:"kind"`
+
+	// Platforms are the platforms of an image, or the ones of the manifests of an image index.
+	Platforms []string `json:"platforms,omitempty"`
+
+	// Size is the size in bytes of the manifest, and for an image the size of its configuration and compressed layers.
+	Size int64 `json:"size"`
+
+	// Parents are the hashes of the objects referencing the package version: the image indices, or its subject if it is
+	// a referrer.
+	Parents []string `json:"parents,omitempty"`
+}
+
+// Inventory lists the versions of a package along with the description of their registry object, the most recent
+// first.
+func Inventory(ctx context.Context, ghClient GithubClient, regClient ContainerRegistryClient, pkgRegistryParams PackageRegistryParams) ([]*InventoryItem, error) {
+	packageVersionByHash, imageByHash, indexByHash, err := fetchObjects(ctx, ghClient, regClient, pkgRegistryParams, pkgRegistryParams.getRepository())
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the parents of each object, and the attestation manifests referenced by the image indices.
+	parentsByHash := make(map[string][]string)
+	attestations := make(map[string]bool)
+	for hash, index := range indexByHash {
+		indexManifest, err := index.IndexManifest()
+		if err != nil {
+			return nil, fmt.Errorf("unable to get the image index manifest: %w", err)
+		}
+
+		seen := make(map[string]bool)
+		for _, manifest := range indexManifest.Manifests {
+			// An index may reference the same manifest several times, it is a single parent.
+			if !seen[manifest.Digest.String()] {
+				parentsByHash[manifest.Digest.String()] = append(parentsByHash[manifest.Digest.String()], hash)
+				seen[manifest.Digest.String()] = true
+			}
+			if manifest.Annotations["vnd.docker.reference.type"] == "attestation-manifest" {
+				attestations[manifest.Digest.String()] = true
+			}
+		}
+	}
+	for hash, subject := range getSubjects(imageByHash, indexByHash) {
+		parentsByHash[hash] = append(parentsByHash[hash], subject)
+	}
+
+	var items []*InventoryItem
+	for hash, version := range packageVersionByHash {
+		item := &InventoryItem{
+			Digest:    hash,
+			VersionID: version.GetID(),
+			Tags:      version.GetMetadata().GetContainer().Tags,
+			Kind:      UnknownKind,
+			Parents:   sortedCopy(parentsByHash[hash]),
+		}
+		if version.CreatedAt != nil {
+			item.CreatedAt = &version.CreatedAt.Time
+		}
+		if version.UpdatedAt != nil {
+			item.UpdatedAt = &version.UpdatedAt.Time
+		}
+
+		if image, found := imageByHash[hash]; found {
+			describeImage(item, image, attestations[hash])
+		} else if index, found := indexByHash[hash]; found {
+			describeIndex(item, index)
+		}
+
+		items = append(items, item)
+	}
+
+	// Sort the items, the most recent first.
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].CreatedAt != nil && items[j].CreatedAt != nil && !items[i].CreatedAt.Equal(*items[j].CreatedAt) {
+			return items[i].CreatedAt.After(*items[j].CreatedAt)
+		}
+		return items[i].Digest < items[j].Digest
+	})
+
+	return items, nil
+}
+
+// describeImage sets the kind, platform and size of an inventory item from its image.
+func describeImage(item *InventoryItem, image v1.Image, attestation bool) {
+	item.Kind = ImageKind
+
+	manifest, err := image.Manifest()
+	if err != nil {
+		log.Warn().Err(err).Str("hash", item.Digest).Msg("unable to retrieve the image manifest")
+		return
+	}
+
+	// Get the kind of the image, from the types of its artifact and layers.
+	artifactType := string(manifest.Config.MediaType)
+	if rawManifest, err := image.RawManifest(); err == nil {
+		var m struct {
+			ArtifactType string `json:"artifactType"`
+		}
+		if json.Unmarshal(rawManifest, &m) == nil && m.ArtifactType != "" {
+			artifactType = m.ArtifactType
+		}
+	}
+	switch {
+	case attestation:
+		item.Kind = AttestationKind
+	case strings.Contains(artifactType, "signature") || strings.Contains(artifactType, "sigstore.bundle"):
+		item.Kind = SignatureKind
+	}
+	for _, layer := range manifest.Layers {
+		switch layer.MediaType {
+		case "application/vnd.dev.cosign.simplesigning.v1+json":
+			item.Kind = SignatureKind
+		case "application/vnd.dsse.envelope.v1+json", "application/vnd.in-toto+json":
+			item.Kind = AttestationKind
+		}
+	}
+
+	// Get the size of the manifest, configuration and layers.
+	if size, err := image.Size(); err == nil {
+		item.Size = size
+	}
+	item.Size += manifest.Config.Size
+	for _, layer := range manifest.Layers {
+		item.Size += layer.Size
+	}
+
+	// Get the platform of the image, the referrers have none.
+	if item.Kind == ImageKind {
+		if configFile, err := image.ConfigFile(); err == nil {
+			if platform := configFile.Platform(); platform != nil && platform.String() != "" {
+				item.Platforms = []string{platform.String()}
+			}
+		}
+	}
+}
+
+// describeIndex sets the kind, platforms and size of an inventory item from its image index.
+func describeIndex(item *InventoryItem, index v1.ImageIndex) {
+	item.Kind = IndexKind
+
+	if size, err := index.Size(); err == nil {
+		item.Size = size
+	}
+
+	indexManifest, err := index.IndexManifest()
+	if err != nil {
+		log.Warn().Err(err).Str("hash", item.Digest).Msg("unable to retrieve the image index manifest")
+		return
+	}
+
+	// Get the platforms of the manifests, ignoring the attestations.
+	for _, manifest := range indexManifest.Manifests {
+		if manifest.Platform == nil || manifest.Platform.OS == "unknown" {
+			continue
+		}
+		if platform := manifest.Platform.String(); platform != "" {
+			item.Platforms = append(item.Platforms, platform)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package pkg
+
+import (
+	"fmt"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"strconv"
+	"time"
+)
+
+const (
+	KeepMarker    = "ghcr-cleaning.keep"
+	ExpiresMarker = "ghcr-cleaning.expires"
+
+	// The expiration date format of the ExpiresMarker.
+	expiresDateLayout = "2006-01-02"
+)
+
+// getImageAnnotations returns the annotations from the manifest of an image.
+func getImageAnnotations(image v1.Image) (map[string]string, error) {
+	manifest, err := image.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve the image manifest: %w", err)
+	}
+
+	if manifest == nil {
+		return nil, nil
+	}
+	return manifest.Annotations, nil
+}
+
+// getKeepReason returns the reason why an object must be kept according to the keep markers found in its labels or
+// annotations, or an empty string if the object is not protected.
+// The `source` parameter indicates where the markers come from (e.g. "label" or "annotation").
+func getKeepReason(markers map[string]string, source string, now time.Time) string {
+	// Check the permanent keep marker.
+	if value, found := markers[KeepMarker]; found {
+		keep, err := strconv.ParseBool(value)
+		if err != nil {
+			// Invalid value, keep the object as it was most likely meant to be protected.
+			return fmt.Sprintf("invalid %s '%s=%s'", source, KeepMarker, value)
+		} else if keep {
+			return fmt.Sprintf("protected by %s '%s=%s'", source, KeepMarker, value)
+		}
+	}
+
+	// Check the expiration marker, the object is protected until the end of the expiration day.
+	if value, found := markers[ExpiresMarker]; found {
+		expires, err := time.Parse(expiresDateLayout, value)
+		if err != nil {
+			// Invalid value, keep the object as it was most likely meant to be protected.
+			return fmt.Sprintf("invalid %s '%s=%s'", source, ExpiresMarker, value)
+		} else if now.Before(expires.AddDate(0, 0, 1)) {
+			return fmt.Sprintf("protected by %s '%s=%s'", source, ExpiresMarker, value)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,168 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/rs/zerolog/log"
+	"strings"
+)
+
+const (
+	RevisionLabel = "org.opencontainers.image.revision"
+	SourceLabel   = "org.opencontainers.image.source"
+)
+
+type LabelFilterParams struct {
+	Enabled bool
+}
+
+// getImageLabels returns the labels from the configuration of an image.
+func getImageLabels(image v1.Image) (map[string]string, error) {
+	configFile, err := image.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve the image configuration: %w", err)
+	}
+
+	if configFile == nil {
+		return nil, nil
+	}
+	return configFile.Config.Labels, nil
+}
+
+// imageRevision is a commit referenced by the image labels, along with the repository it belongs to.
+type imageRevision struct {
+	owner      string
+	repository string
+	sha        string
+}
+
+func (r imageRevision) String() string {
+	return fmt.Sprintf("%s/%s@%s", r.owner, r.repository, r.sha)
+}
+
+// getRevision returns the commit referenced by the labels, or nil if there is none. The commit belongs to the source
+// repository referenced by the labels if any, to the specified repository otherwise. The revision is ignored if the
+// source repository cannot be parsed, as it cannot be looked up.
+func getRevision(labels map[string]string, owner, repository string) *imageRevision {
+	sha := labels[RevisionLabel]
+	if sha == "" {
+		return nil
+	}
+
+	if source, found := labels[SourceLabel]; found {
+		var ok bool
+		owner, repository, ok = parseSourceRepository(source)
+		if !ok {
+			log.Trace().Str("revision", sha).Str("source", source).Msg("revision ignored, the source repository cannot be parsed")
+			return nil
+		}
+	}
+
+	return &imageRevision{owner: owner, repository: repository, sha: sha}
+}
+
+// parseSourceRepository returns the owner and the name of the repository pointed to by a repository URL, e.g.
+// https://github.com/owner/repository.git or git@github.com:owner/repository.git.
+func parseSourceRepository(url string) (string, string, bool) {
+	path := strings.TrimSuffix(strings.TrimSuffix(url, "/"), ".git")
+	path = strings.ReplaceAll(path, ":", "/")
+
+	segments := strings.Split(path, "/")
+	if len(segments) < 3 {
+		return "", "", false
+	}
+
+	owner, repository := segments[len(segments)-2], segments[len(segments)-1]
+	if owner == "" || repository == "" {
+		return "", "", false
+	}
+	return owner, repository, true
+}
+
+// revisionPullRequests is the summary of the pull requests associated to a revision.
+type revisionPullRequests struct {
+	// Whether the revision is associated to at least one pull request.
+	found bool
+
+	// Whether at least one of the pull requests is not closed.
+	open bool
+}
+
+// revisionChecker checks whether the commits referenced by the image labels are obsolete. The result is cached per
+// revision.
+type revisionChecker struct {
+	ghClient               GithubClient
+	commits                *commitChecker
+	pullRequestsByRevision map[imageRevision]revisionPullRequests
+}
+
+func newRevisionChecker(ghClient GithubClient, commits *commitChecker) *revisionChecker {
+	return &revisionChecker{
+		ghClient:               ghClient,
+		commits:                commits,
+		pullRequestsByRevision: make(map[imageRevision]revisionPullRequests),
+	}
+}
+
+// getPullRequests returns the summary of the pull requests associated to a revision.
+func (c *revisionChecker) getPullRequests(ctx context.Context, revision imageRevision) (revisionPullRequests, error) {
+	// Check if the revision has already been checked.
+	if summary, found := c.pullRequestsByRevision[revision]; found {
+		return summary, nil
+	}
+
+	pullRequests, err := c.ghClient.GetAllPullRequestsForCommit(ctx, revision.owner, revision.repository, revision.sha)
+	if err != nil {
+		return revisionPullRequests{}, fmt.Errorf("unable to retrieve the pull requests of the commit: %w", err)
+	}
+
+	summary := revisionPullRequests{found: len(pullRequests) > 0}
+	for _, pr := range pullRequests {
+		if pr.GetState() != "closed" {
+			summary.open = true
+			break
+		}
+	}
+
+	log.Trace().Stringer("revision", revision).Bool("found", summary.found).Bool("open", summary.open).Msg("revision pull requests checked")
+	c.pullRequestsByRevision[revision] = summary
+
+	return summary, nil
+}
+
+// hasOpenPullRequest returns whether a revision is associated to a pull request that is not closed.
+func (c *revisionChecker) hasOpenPullRequest(ctx context.Context, revision imageRevision) (bool, error) {
+	summary, err := c.getPullRequests(ctx, revision)
+	return summary.open, err
+}
+
+// isRevisionObsolete returns whether a revision is obsolete, along with the detail of the check. A revision associated
+// to pull requests is valid as long as one of them is not closed. Otherwise, it is valid if it is reachable from a
+// protected reference, which is only known for the repository of the commit policy.
+func (c *revisionChecker) isRevisionObsolete(ctx context.Context, revision imageRevision) (bool, string, error) {
+	summary, err := c.getPullRequests(ctx, revision)
+	if err != nil {
+		return false, "", err
+	}
+
+	if summary.found {
+		if summary.open {
+			return false, fmt.Sprintf("revision %s is associated to an open pull request", revision), nil
+		}
+		return true, fmt.Sprintf("the pull requests of revision %s are all closed", revision), nil
+	}
+
+	if !strings.EqualFold(revision.owner, c.commits.params.Owner) || !strings.EqualFold(revision.repository, c.commits.params.Repository) {
+		return true, fmt.Sprintf("revision %s is not associated to any pull request", revision), nil
+	}
+
+	reachable, err := c.commits.isCommitReachable(ctx, revision.sha)
+	if err != nil {
+		return false, "", fmt.Errorf("unable to check the commit reachability: %w", err)
+	}
+	if reachable {
+		return false, fmt.Sprintf("revision %s is reachable from a protected reference", revision), nil
+	}
+	return true, fmt.Sprintf("revision %s is neither associated to a pull request nor reachable from a protected reference", revision), nil
+}
@@ -0,0 +1,157 @@
+package pkg
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Plan is the result of the analysis of a package, it contains the decision taken for each of its versions. The
+// decisions are in deletion order: an object comes before the objects it refers to, so that an interrupted cleaning
+// never leaves an object referring to a deleted one.
+type Plan struct {
+	Decisions []*Decision `json:"decisions"`
+
+	// Rewrites are the image indices rewritten without the pruned platforms, recording the hash of the old indices.
+	Rewrites []*IndexRewrite `json:"rewrites,omitempty"`
+}
+
+// Decision is the decision taken for a package version, along with the reason of this decision.
+type Decision struct {
+	Hash   string   `json:"hash"`
+	Tags   []string `json:"tags,omitempty"`
+	Delete bool     `json:"delete"`
+	Reason string   `json:"reason"`
+
+	// Provenance tells how the decision derives from the ones taken for the related objects, e.g. "deleted because its
+	// only parent sha256:... was deleted", it is empty for a decision taken on the object alone.
+	Provenance string `json:"provenance,omitempty"`
+
+	// Manifests are the hashes of the manifests referenced by an image index, and Subject the hash of the object a
+	// referrer (e.g. a signature) refers to. Only the package versions whose registry object was retrieved are listed.
+	Manifests []string `json:"manifests,omitempty"`
+	Subject   string   `json:"subject,omitempty"`
+
+	// Deleted tells whether the package version has actually been deleted, and DeletedBy how: GithubDeletion or
+	// RegistryDeletion.
+	Deleted   bool   `json:"deleted,omitempty"`
+	DeletedBy string `json:"deletedBy,omitempty"`
+}
+
+// HashesToDelete returns the hashes of the package versions to delete.
+func (p *Plan) HashesToDelete() []string {
+	var hashes []string
+	for _, decision := range p.Decisions {
+		if decision.Delete {
+			hashes = append(hashes, decision.Hash)
+		}
+	}
+	return hashes
+}
+
+// DeletionStatus returns the hashes of the package versions to delete that have been deleted, and of those that have
+// not, e.g. because the cleaning was interrupted or failed.
+func (p *Plan) DeletionStatus() (deleted, notDeleted []string) {
+	for _, decision := range p.Decisions {
+		if !decision.Delete {
+			continue
+		}
+
+		if decision.Deleted {
+			deleted = append(deleted, decision.Hash)
+		} else {
+			notDeleted = append(notDeleted, decision.Hash)
+		}
+	}
+	return deleted, notDeleted
+}
+
+// sortDecisions sorts the decisions in deletion order, using the hashes of the objects referred to by each object: an
+// image index comes before its manifests and a referrer before its subject. The ties are broken by hash, so that the
+// order is reproducible.
+func sortDecisions(decisions []*Decision, referredHashesByHash map[string][]string) {
+	decisionByHash := make(map[string]*Decision)
+	for _, decision := range decisions {
+		decisionByHash[decision.Hash] = decision
+	}
+
+	// Count the objects referring to each object, ignoring the unknown objects.
+	referringCount := make(map[string]int)
+	for hash, referredHashes := range referredHashesByHash {
+		if decisionByHash[hash] == nil {
+			continue
+		}
+		for _, referredHash := range referredHashes {
+			if decisionByHash[referredHash] != nil {
+				referringCount[referredHash]++
+			}
+		}
+	}
+
+	// Visit the objects referred to by no remaining object, the smallest hash first.
+	ready := &hashHeap{}
+	for hash := range decisionByHash {
+		if referringCount[hash] == 0 {
+			heap.Push(ready, hash)
+		}
+	}
+
+	sorted := make([]*Decision, 0, len(decisions))
+	for ready.Len() > 0 {
+		hash := heap.Pop(ready).(string)
+		sorted = append(sorted, decisionByHash[hash])
+		delete(decisionByHash, hash)
+
+		for _, referredHash := range referredHashesByHash[hash] {
+			if decisionByHash[referredHash] == nil {
+				continue
+			}
+			referringCount[referredHash]--
+			if referringCount[referredHash] == 0 {
+				heap.Push(ready, referredHash)
+			}
+		}
+	}
+
+	// The objects of a cycle, which cannot exist between content-addressed objects, are added in hash order.
+	var remaining []*Decision
+	for _, decision := range decisionByHash {
+		remaining = append(remaining, decision)
+	}
+	sort.Slice(remaining, func(i, j int) bool {
+		return remaining[i].Hash < remaining[j].Hash
+	})
+
+	copy(decisions, append(sorted, remaining...))
+}
+
+// hashHeap is a min-heap of hashes.
+type hashHeap []string
+
+func (h hashHeap) Len() int            { return len(h) }
+func (h hashHeap) Less(i, j int) bool  { return h[i] < h[j] }
+func (h hashHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *hashHeap) Push(x interface{}) { *h = append(*h, x.(string)) }
+func (h *hashHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// WriteFile writes the plan in JSON format to a file.
+func (p *Plan) WriteFile(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to serialize the plan: %w", err)
+	}
+
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to write the plan to file '%s': %w", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"strings"
+)
+
+// Preflight checks, before any work is done, that the GitHub tokens have the permissions required by the cleaning:
+// read the package and list its versions, delete the versions (unless in dry run mode or if they are deleted through the
+// registry) and read the pull requests of the configured repository, if a policy needs them. With the registry backend,
+// the package is not checked as the GitHub Packages API is not used. All the problems found are reported at once in the
+// returned error.
+func Preflight(ctx context.Context, ghClient GithubClient, prFilterParams PullRequestFilterParams, commitFilterParams CommitFilterParams, labelFilterParams LabelFilterParams, pkgRegistryParams PackageRegistryParams, dryRun bool) error {
+	var problems []string
+	githubBackend := pkgRegistryParams.Backend != RegistryBackend
+
+	// Check the scopes of the packages token, if known and used.
+	scopes, err := ghClient.GetTokenScopes(ctx)
+	if err != nil {
+		// The scopes are only an early hint, the permissions are still checked below.
+		log.Warn().Err(err).Msg("unable to retrieve the scopes of the tokens, they are considered unknown")
+		scopes = TokenScopes{}
+	}
+	if githubBackend {
+		requiredScopes := []string{"read:packages"}
+		if !dryRun && pkgRegistryParams.getDeletionStrategy() != RegistryDeletion {
+			requiredScopes = append(requiredScopes, "delete:packages")
+		}
+		if !dryRun && len(pkgRegistryParams.PrunedPlatforms) > 0 {
+			// The image indices without the pruned platforms are pushed.
+			requiredScopes = append(requiredScopes, "write:packages")
+		}
+
+		if scopes.Packages != nil {
+			missingScopes := getMissingScopes(scopes.Packages, requiredScopes)
+			if len(missingScopes) > 0 {
+				problems = append(problems, fmt.Sprintf("the packages token lacks the scope(s) '%s'", strings.Join(missingScopes, ", ")))
+			}
+		} else if !dryRun {
+			log.Debug().Msg("the scopes of the packages token are unknown, the delete permission cannot be checked before the deletion")
+		}
+	}
+
+	if githubBackend {
+		// Check that the package can be read.
+		_, err = ghClient.GetContainerPackage(ctx, pkgRegistryParams.User, pkgRegistryParams.PackageName)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("the package cannot be read: %s", err))
+		}
+
+		// Check that the package versions can be listed.
+		_, err = ghClient.GetLatestContainerPackageVersion(ctx, pkgRegistryParams.User, pkgRegistryParams.PackageName)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("the package versions cannot be listed: %s", err))
+		}
+	}
+
+	// Check that the pull requests of the repository can be read, if they are looked up by the pull request tags policy,
+	// the commit tags policy or the revision label lookup.
+	needsPullRequests := len(prFilterParams.TagRegexes) > 0 || commitFilterParams.TagRegex != nil || labelFilterParams.Enabled
+	if needsPullRequests {
+		_, err = ghClient.GetLatestPullRequest(ctx, prFilterParams.Owner, prFilterParams.Repository)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("the pull requests cannot be read: %s", err))
+
+			// Without the 'repo' scope, only the pull requests of the public repositories can be read.
+			if scopes.Repositories != nil {
+				missingScopes := getMissingScopes(scopes.Repositories, []string{"repo"})
+				if len(missingScopes) > 0 {
+					problems = append(problems, fmt.Sprintf("the repositories token lacks the scope(s) '%s'", strings.Join(missingScopes, ", ")))
+				}
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("preflight check failed:\n- %s", strings.Join(problems, "\n- "))
+	}
+
+	log.Debug().Msg("preflight check passed")
+	return nil
+}
+
+// getMissingScopes returns the required scopes not granted, either directly or through a broader scope.
+func getMissingScopes(grantedScopes, requiredScopes []string) []string {
+	// The scopes granting other ones.
+	impliedScopes := map[string][]string{
+		"write:packages": {"read:packages"},
+	}
+
+	granted := make(map[string]struct{})
+	for _, scope := range grantedScopes {
+		granted[scope] = struct{}{}
+		for _, implied := range impliedScopes[scope] {
+			granted[implied] = struct{}{}
+		}
+	}
+
+	var missingScopes []string
+	for _, scope := range requiredScopes {
+		if _, found := granted[scope]; !found {
+			missingScopes = append(missingScopes, scope)
+		}
+	}
+	return missingScopes
+}
@@ -0,0 +1,189 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"github.com/google/go-github/v49/github"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/suite"
+	"regexp"
+	"testing"
+)
+
+//
+// Test suite definition.
+//
+
+type PreflightTestSuite struct {
+	suite.Suite
+}
+
+func TestPreflightTestSuite(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	suite.Run(t, new(PreflightTestSuite))
+}
+
+//
+// Tests.
+//
+
+var preflightPkgRegistryParams = PackageRegistryParams{
+	User:        "user",
+	PackageName: "package",
+}
+
+var preflightPrFilterParams = PullRequestFilterParams{
+	Owner:      "owner",
+	Repository: "repository",
+	TagRegexes: []*regexp.Regexp{regexp.MustCompile(DefaultPrTagPattern)},
+}
+
+func (s *PreflightTestSuite) TestPreflightOk() {
+	ghClient := new(githubClientMock)
+	ghClient.
+		On("GetTokenScopes").
+		Return(TokenScopes{Packages: []string{"write:packages", "delete:packages"}, Repositories: []string{}}, nil).
+		On("GetContainerPackage", "user", "package").
+		Return(&github.Package{}, nil).
+		On("GetLatestContainerPackageVersion", "user", "package").
+		Return(&github.PackageVersion{}, nil).
+		On("GetLatestPullRequest", "owner", "repository").
+		Return((*github.PullRequest)(nil), nil)
+
+	err := Preflight(context.Background(), ghClient, preflightPrFilterParams, CommitFilterParams{}, LabelFilterParams{}, preflightPkgRegistryParams, false)
+
+	ghClient.AssertExpectations(s.T())
+	s.Require().NoError(err)
+}
+
+func (s *PreflightTestSuite) TestPreflightMissingScopes() {
+	ghClient := new(githubClientMock)
+	ghClient.
+		On("GetTokenScopes").
+		Return(TokenScopes{Packages: []string{"repo"}, Repositories: []string{"repo"}}, nil).
+		On("GetContainerPackage", "user", "package").
+		Return((*github.Package)(nil), errors.New("forbidden")).
+		On("GetLatestContainerPackageVersion", "user", "package").
+		Return((*github.PackageVersion)(nil), errors.New("forbidden")).
+		On("GetLatestPullRequest", "owner", "repository").
+		Return((*github.PullRequest)(nil), errors.New("not found"))
+
+	err := Preflight(context.Background(), ghClient, preflightPrFilterParams, CommitFilterParams{}, LabelFilterParams{}, preflightPkgRegistryParams, false)
+
+	r := s.Require()
+	r.Error(err)
+	r.Contains(err.Error(), "the packages token lacks the scope(s) 'read:packages, delete:packages'")
+	r.Contains(err.Error(), "the package cannot be read: forbidden")
+	r.Contains(err.Error(), "the package versions cannot be listed: forbidden")
+	r.Contains(err.Error(), "the pull requests cannot be read: not found")
+}
+
+func (s *PreflightTestSuite) TestPreflightPrunedPlatformsScopes() {
+	ghClient := new(githubClientMock)
+	ghClient.
+		On("GetTokenScopes").
+		Return(TokenScopes{Packages: []string{"read:packages", "delete:packages"}, Repositories: []string{}}, nil).
+		On("GetContainerPackage", "user", "package").
+		Return(&github.Package{}, nil).
+		On("GetLatestContainerPackageVersion", "user", "package").
+		Return(&github.PackageVersion{}, nil).
+		On("GetLatestPullRequest", "owner", "repository").
+		Return((*github.PullRequest)(nil), nil)
+
+	pkgRegistryParams := preflightPkgRegistryParams
+	pkgRegistryParams.PrunedPlatforms = []string{"linux/386"}
+	err := Preflight(context.Background(), ghClient, preflightPrFilterParams, CommitFilterParams{}, LabelFilterParams{}, pkgRegistryParams, false)
+
+	// The pruned image indices are pushed.
+	r := s.Require()
+	r.Error(err)
+	r.Contains(err.Error(), "the packages token lacks the scope(s) 'write:packages'")
+}
+
+func (s *PreflightTestSuite) TestPreflightMissingRepositoriesScopes() {
+	ghClient := new(githubClientMock)
+	ghClient.
+		On("GetTokenScopes").
+		Return(TokenScopes{Packages: []string{"write:packages", "delete:packages"}, Repositories: []string{"read:org"}}, nil).
+		On("GetContainerPackage", "user", "package").
+		Return(&github.Package{}, nil).
+		On("GetLatestContainerPackageVersion", "user", "package").
+		Return(&github.PackageVersion{}, nil).
+		On("GetLatestPullRequest", "owner", "repository").
+		Return((*github.PullRequest)(nil), errors.New("not found"))
+
+	err := Preflight(context.Background(), ghClient, preflightPrFilterParams, CommitFilterParams{}, LabelFilterParams{}, preflightPkgRegistryParams, false)
+
+	r := s.Require()
+	r.Error(err)
+	r.Contains(err.Error(), "the pull requests cannot be read: not found")
+	r.Contains(err.Error(), "the repositories token lacks the scope(s) 'repo'")
+	r.NotContains(err.Error(), "packages token")
+}
+
+func (s *PreflightTestSuite) TestPreflightScopesError() {
+	ghClient := new(githubClientMock)
+	ghClient.
+		On("GetTokenScopes").
+		Return(TokenScopes{}, errors.New("unable to retrieve the scopes of the packages token: timeout")).
+		On("GetContainerPackage", "user", "package").
+		Return(&github.Package{}, nil).
+		On("GetLatestContainerPackageVersion", "user", "package").
+		Return(&github.PackageVersion{}, nil).
+		On("GetLatestPullRequest", "owner", "repository").
+		Return((*github.PullRequest)(nil), nil)
+
+	err := Preflight(context.Background(), ghClient, preflightPrFilterParams, CommitFilterParams{}, LabelFilterParams{}, preflightPkgRegistryParams, false)
+
+	// The scopes are considered unknown, the other checks are still done.
+	ghClient.AssertExpectations(s.T())
+	s.Require().NoError(err)
+}
+
+func (s *PreflightTestSuite) TestPreflightNoPullRequestPolicy() {
+	// The pull requests are not looked up, so they are not read.
+	ghClient := new(githubClientMock)
+	ghClient.
+		On("GetTokenScopes").
+		Return(TokenScopes{Packages: []string{"delete:packages", "read:packages"}, Repositories: []string{}}, nil).
+		On("GetContainerPackage", "user", "package").
+		Return(&github.Package{}, nil).
+		On("GetLatestContainerPackageVersion", "user", "package").
+		Return(&github.PackageVersion{}, nil)
+
+	prFilterParams := preflightPrFilterParams
+	prFilterParams.TagRegexes = nil
+	err := Preflight(context.Background(), ghClient, prFilterParams, CommitFilterParams{}, LabelFilterParams{}, preflightPkgRegistryParams, false)
+
+	ghClient.AssertExpectations(s.T())
+	s.Require().NoError(err)
+
+	// The revision label lookup needs them.
+	ghClient.
+		On("GetLatestPullRequest", "owner", "repository").
+		Return(&github.PullRequest{}, nil)
+
+	err = Preflight(context.Background(), ghClient, prFilterParams, CommitFilterParams{}, LabelFilterParams{Enabled: true}, preflightPkgRegistryParams, false)
+
+	ghClient.AssertExpectations(s.T())
+	s.Require().NoError(err)
+}
+
+func (s *PreflightTestSuite) TestPreflightDryRunUnknownScopes() {
+	ghClient := new(githubClientMock)
+	ghClient.
+		On("GetTokenScopes").
+		Return(TokenScopes{}, nil).
+		On("GetContainerPackage", "user", "package").
+		Return(&github.Package{}, nil).
+		On("GetLatestContainerPackageVersion", "user", "package").
+		Return((*github.PackageVersion)(nil), nil).
+		On("GetLatestPullRequest", "owner", "repository").
+		Return(&github.PullRequest{}, nil)
+
+	err := Preflight(context.Background(), ghClient, preflightPrFilterParams, CommitFilterParams{}, LabelFilterParams{}, preflightPkgRegistryParams, true)
+
+	ghClient.AssertExpectations(s.T())
+	s.Require().NoError(err)
+}
@@ -0,0 +1,186 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-github/v49/github"
+	"github.com/rs/zerolog/log"
+)
+
+// IndexRewrite is the rewrite of an image index without the manifests of the pruned platforms, the new index being
+// pushed under the tags of the old one.
+type IndexRewrite struct {
+	OldHash string   `json:"oldHash"`
+	NewHash string   `json:"newHash"`
+	Tags    []string `json:"tags"`
+
+	// PrunedPlatforms are the platforms removed from the index, and PrunedManifests the hashes of their manifests,
+	// including the attestation manifests referring to them.
+	PrunedPlatforms []string `json:"prunedPlatforms"`
+	PrunedManifests []string `json:"prunedManifests"`
+
+	// Pushed tells whether the new index has been pushed, it is false in dry run mode.
+	Pushed bool `json:"pushed,omitempty"`
+}
+
+// parsePlatforms parses platforms in the format os/architecture[/variant], e.g. `linux/arm/v6`.
+func parsePlatforms(values []string) ([]v1.Platform, error) {
+	var platforms []v1.Platform
+	for _, value := range values {
+		platform, err := v1.ParsePlatform(value)
+		if err != nil || platform.OS == "" || platform.Architecture == "" {
+			return nil, fmt.Errorf("invalid platform '%s', must be os/architecture[/variant]", value)
+		}
+		platforms = append(platforms, *platform)
+	}
+	return platforms, nil
+}
+
+// matchPlatform returns whether a platform is one of the pruned platforms, a pruned platform without variant matching
+// all the variants of its architecture.
+func matchPlatform(platform *v1.Platform, prunedPlatforms []v1.Platform) bool {
+	if platform == nil {
+		return false
+	}
+	for _, pruned := range prunedPlatforms {
+		if platform.OS == pruned.OS && platform.Architecture == pruned.Architecture &&
+			(pruned.Variant == "" || platform.Variant == pruned.Variant) {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneIndexPlatforms rewrites the kept image indices without the manifests of the pruned platforms and, unless in dry
+// run mode, pushes them under the same tags. It returns the rewrites along with copies of the objects updated as if the
+// package versions had been listed after the push: the old indices are untagged and the new ones are added, so that
+// evaluating them again deletes the old indices along with the manifests of the pruned platforms. The given objects
+// are left untouched.
+//
+// Only the tagged indices referenced by no other object are rewritten, as the digest of an index changes with its
+// manifests; the indices having referrers (e.g. signatures) are left untouched, as the referrers would be orphaned, and
+// so are the indices protected by a keep marker.
+func pruneIndexPlatforms(ctx context.Context, regClient ContainerRegistryClient, repository string, prunedPlatforms []v1.Platform, plan *Plan, items map[string]*registryItem, packageVersionByHash map[string]*github.PackageVersion, indexByHash map[string]v1.ImageIndex, dryRun bool) ([]*IndexRewrite, map[string]*github.PackageVersion, map[string]v1.ImageIndex, error) {
+	prunedVersionByHash := make(map[string]*github.PackageVersion, len(packageVersionByHash))
+	for hash, version := range packageVersionByHash {
+		prunedVersionByHash[hash] = version
+	}
+	prunedIndexByHash := make(map[string]v1.ImageIndex, len(indexByHash))
+	for hash, index := range indexByHash {
+		prunedIndexByHash[hash] = index
+	}
+
+	var rewrites []*IndexRewrite
+	for _, decision := range plan.Decisions {
+		index, found := indexByHash[decision.Hash]
+		if !found || decision.Delete || len(decision.Tags) == 0 {
+			continue
+		}
+
+		// Check that the index can be rewritten.
+		item := items[decision.Hash]
+		if len(item.parents) > 0 {
+			log.Debug().Str("hash", decision.Hash).Msg("image index referenced by another object, its platforms are not pruned")
+			continue
+		}
+		if item.keepMarker {
+			log.Debug().Str("hash", decision.Hash).Msg("image index protected by a keep marker, its platforms are not pruned")
+			continue
+		}
+
+		// Get the manifests to prune.
+		indexManifest, err := index.IndexManifest()
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("unable to get the image index manifest: %w", err)
+		}
+
+		pruned := make(map[string]bool)
+		var platforms []string
+		for _, manifest := range indexManifest.Manifests {
+			if matchPlatform(manifest.Platform, prunedPlatforms) {
+				pruned[manifest.Digest.String()] = true
+				platforms = append(platforms, manifest.Platform.String())
+			}
+		}
+		if len(pruned) == 0 {
+			continue
+		}
+
+		hasReferrers := false
+		for _, reference := range item.references {
+			hasReferrers = hasReferrers || reference.referrer
+		}
+		if hasReferrers {
+			log.Warn().Str("hash", decision.Hash).Strs("platforms", platforms).Msg("image index having referrers, its platforms are not pruned")
+			continue
+		}
+
+		// Prune the attestation manifests of the pruned platforms too, and keep at least one platform.
+		remaining := 0
+		for _, manifest := range indexManifest.Manifests {
+			if pruned[manifest.Annotations["vnd.docker.reference.digest"]] {
+				pruned[manifest.Digest.String()] = true
+			} else if !pruned[manifest.Digest.String()] && manifest.Annotations["vnd.docker.reference.type"] != "attestation-manifest" {
+				remaining++
+			}
+		}
+		if remaining == 0 {
+			log.Warn().Str("hash", decision.Hash).Strs("platforms", platforms).Msg("all the platforms of the image index would be pruned, it is left untouched")
+			continue
+		}
+
+		// Rewrite the index.
+		newIndex := mutate.RemoveManifests(index, func(desc v1.Descriptor) bool {
+			return pruned[desc.Digest.String()]
+		})
+		newDigest, err := newIndex.Digest()
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("unable to compute the digest of the pruned image index: %w", err)
+		}
+
+		rewrite := &IndexRewrite{
+			OldHash:         decision.Hash,
+			NewHash:         newDigest.String(),
+			Tags:            decision.Tags,
+			PrunedPlatforms: platforms,
+		}
+		for hash := range pruned {
+			rewrite.PrunedManifests = append(rewrite.PrunedManifests, hash)
+		}
+		rewrite.PrunedManifests = sortedCopy(rewrite.PrunedManifests)
+
+		if !dryRun {
+			err = regClient.WriteIndex(ctx, repository, newIndex, decision.Tags)
+			if err != nil {
+				log.Warn().Err(err).Str("hash", decision.Hash).Msg("unable to push the pruned image index")
+				continue
+			}
+			rewrite.Pushed = true
+			log.Info().Str("old-hash", rewrite.OldHash).Str("new-hash", rewrite.NewHash).Strs("platforms", platforms).Msg("image index platforms pruned")
+		}
+		rewrites = append(rewrites, rewrite)
+
+		// Move the tags from the old index to the new one.
+		oldVersion := *prunedVersionByHash[decision.Hash]
+		oldVersion.Metadata = &github.PackageMetadata{
+			PackageType: oldVersion.GetMetadata().PackageType,
+			Container:   &github.PackageContainerMetadata{},
+		}
+		prunedVersionByHash[decision.Hash] = &oldVersion
+		newVersion := github.PackageVersion{Name: github.String(rewrite.NewHash)}
+		if existingVersion, found := prunedVersionByHash[rewrite.NewHash]; found {
+			// The same index has already been pushed, e.g. by a previous run.
+			newVersion = *existingVersion
+		}
+		newVersion.Metadata = &github.PackageMetadata{
+			PackageType: oldVersion.GetMetadata().PackageType,
+			Container:   &github.PackageContainerMetadata{Tags: decision.Tags},
+		}
+		prunedVersionByHash[rewrite.NewHash] = &newVersion
+		prunedIndexByHash[rewrite.NewHash] = newIndex
+	}
+
+	return rewrites, prunedVersionByHash, prunedIndexByHash, nil
+}
@@ -0,0 +1,98 @@
+package pkg
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultPrTagPattern = "^pr-(\\d+).*"
+
+	// The names of the optional capture groups of the pull request tag regex.
+	PrIdGroup         = "id"
+	PrRepositoryGroup = "repo"
+)
+
+type PullRequestFilterParams struct {
+	Owner      string
+	Repository string
+
+	// TagRegexes are the regular expressions used to match the pull request tags, the first matching one is used.
+	TagRegexes []*regexp.Regexp
+
+	// RepositoryByName maps the values captured by the `repo` group of the tag regex to repositories (format
+	// owner/repository). A captured value absent from the map is considered to be a repository of the owner.
+	RepositoryByName map[string]string
+}
+
+// NewPrTagRegex compiles and validates a pull request tag regular expression.
+// It must include either an `id` named capture group or, if it has no `repo` named capture group, at least one capture
+// group for the pull request id.
+func NewPrTagRegex(pattern string) (*regexp.Regexp, error) {
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pull request tag regex '%s': %w", pattern, err)
+	}
+
+	if regex.SubexpIndex(PrIdGroup) < 0 {
+		if regex.SubexpIndex(PrRepositoryGroup) >= 0 {
+			return nil, fmt.Errorf("invalid pull request tag regex '%s': a '%s' capture group is required along with the '%s' one", pattern, PrIdGroup, PrRepositoryGroup)
+		}
+		if regex.NumSubexp() < 1 {
+			return nil, fmt.Errorf("invalid pull request tag regex '%s': a capture group is required for the pull request id", pattern)
+		}
+	}
+
+	return regex, nil
+}
+
+// matchPullRequestTag returns the first pull request tag regex matching a tag and its submatches, or nil if there is
+// none.
+func matchPullRequestTag(prFilterParams PullRequestFilterParams, tag string) (*regexp.Regexp, []string) {
+	for _, regex := range prFilterParams.TagRegexes {
+		if matches := regex.FindStringSubmatch(tag); matches != nil {
+			return regex, matches
+		}
+	}
+	return nil, nil
+}
+
+// getPullRequestReference returns the owner, repository and id of the pull request a tag is related to, from the
+// submatches of the tag regex.
+func getPullRequestReference(prFilterParams PullRequestFilterParams, regex *regexp.Regexp, matches []string) (string, string, int, error) {
+	// Get the pull request id, from the `id` group if any or from the first group otherwise.
+	idIndex := regex.SubexpIndex(PrIdGroup)
+	if idIndex < 0 {
+		idIndex = 1
+	}
+	if idIndex >= len(matches) {
+		return "", "", 0, fmt.Errorf("no capture group for the pull request id in regex '%s'", regex)
+	}
+
+	idStr := matches[idIndex]
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("unable to parse pull request identifier '%s': %w", idStr, err)
+	}
+
+	// Get the pull request repository, from the `repo` group if any or from the default repository otherwise.
+	owner := prFilterParams.Owner
+	repository := prFilterParams.Repository
+	if i := regex.SubexpIndex(PrRepositoryGroup); i >= 0 && matches[i] != "" {
+		name := matches[i]
+		if fullName, found := prFilterParams.RepositoryByName[name]; found {
+			ownerAndRepo := strings.Split(fullName, "/")
+			if len(ownerAndRepo) != 2 {
+				return "", "", 0, fmt.Errorf("invalid repository format '%s' for name '%s', must be owner/repository", fullName, name)
+			}
+			owner = ownerAndRepo[0]
+			repository = ownerAndRepo[1]
+		} else {
+			repository = name
+		}
+	}
+
+	return owner, repository, id, nil
+}
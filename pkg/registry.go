@@ -1,39 +1,130 @@
 package pkg
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
 	"github.com/google/go-containerregistry/pkg/v1/types"
+	"golang.org/x/oauth2"
+	"net/http"
+	"time"
 )
 
+// DefaultRegistryRequestTimeout is the default timeout of each request to the container registry.
+const DefaultRegistryRequestTimeout = time.Minute
+
 type ContainerRegistryClient interface {
-	GetRegistryObjectFromHash(repository, hash string) (v1.Image, v1.ImageIndex, error)
+	GetRegistryObjectFromHash(ctx context.Context, repository, hash string) (v1.Image, v1.ImageIndex, error)
+
+	DeleteRegistryObject(ctx context.Context, repository, hash string) error
+
+	ListTags(ctx context.Context, repository string) ([]string, error)
 
-	DeleteRegistryObject(repository, hash string) error
+	GetTagHash(ctx context.Context, repository, tag string) (string, error)
+
+	GetReferrers(ctx context.Context, repository, hash string) ([]string, error)
+
+	WriteIndex(ctx context.Context, repository string, index v1.ImageIndex, tags []string) error
 }
 
 type containerRegistryClientImpl struct {
-	auth authn.Authenticator
+	// The option providing the authentication to the remote calls.
+	authOption remote.Option
+
+	// The transport of the remote calls, retrying the requests on transient errors.
+	transport http.RoundTripper
+}
+
+// newContainerRegistryClient returns a container registry client whose requests time out after requestTimeout and are
+// retried on transient errors.
+func newContainerRegistryClient(authOption remote.Option, requestTimeout time.Duration) *containerRegistryClientImpl {
+	transport := newRetryTransport(remote.DefaultTransport)
+	transport.attemptTimeout = requestTimeout
+
+	return &containerRegistryClientImpl{
+		authOption: authOption,
+		transport:  transport,
+	}
 }
 
 // NewContainerRegistryClient returns an initialized OCI container registry client
-func NewContainerRegistryClient(userName, password string) (ContainerRegistryClient, error) {
+func NewContainerRegistryClient(userName, password string, requestTimeout time.Duration) (ContainerRegistryClient, error) {
 	// Build the Docker registry authentication data.
 	auth := &authn.Basic{
 		Username: userName,
 		Password: password,
 	}
 
-	return &containerRegistryClientImpl{
-		auth: auth,
+	return newContainerRegistryClient(remote.WithAuth(auth), requestTimeout), nil
+}
+
+// NewContainerRegistryClientFromTokenSource returns an initialized OCI container registry client, authenticated with the
+// tokens of a token source
+func NewContainerRegistryClientFromTokenSource(userName string, tokenSource oauth2.TokenSource, requestTimeout time.Duration) (ContainerRegistryClient, error) {
+	auth := &tokenSourceAuthenticator{
+		userName:    userName,
+		tokenSource: tokenSource,
+	}
+
+	return newContainerRegistryClient(remote.WithAuth(auth), requestTimeout), nil
+}
+
+// NewContainerRegistryClientFromKeychain returns an initialized OCI container registry client, authenticated with the
+// credentials of the default keychain: the Docker configuration file (`~/.docker/config.json` or `$DOCKER_CONFIG`) and
+// the credential helpers it references
+func NewContainerRegistryClientFromKeychain(requestTimeout time.Duration) (ContainerRegistryClient, error) {
+	return newContainerRegistryClient(remote.WithAuthFromKeychain(authn.DefaultKeychain), requestTimeout), nil
+}
+
+// GetKeychainPassword returns the password or token stored in the default keychain for a registry, or an empty string if
+// there is none
+func GetKeychainPassword(registry string) (string, error) {
+	reg, err := name.NewRegistry(registry)
+	if err != nil {
+		return "", fmt.Errorf("invalid registry '%s': %w", registry, err)
+	}
+
+	auth, err := authn.DefaultKeychain.Resolve(reg)
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve the credentials of registry '%s' from the keychain: %w", registry, err)
+	}
+
+	authConfig, err := auth.Authorization()
+	if err != nil {
+		return "", fmt.Errorf("unable to retrieve the credentials of registry '%s' from the keychain: %w", registry, err)
+	}
+
+	if authConfig.Password != "" {
+		return authConfig.Password, nil
+	}
+	return authConfig.IdentityToken, nil
+}
+
+// tokenSourceAuthenticator is a registry authenticator using the current token of a token source as password.
+type tokenSourceAuthenticator struct {
+	userName    string
+	tokenSource oauth2.TokenSource
+}
+
+func (a *tokenSourceAuthenticator) Authorization() (*authn.AuthConfig, error) {
+	token, err := a.tokenSource.Token()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get a token for the registry authentication: %w", err)
+	}
+
+	return &authn.AuthConfig{
+		Username: a.userName,
+		Password: token.AccessToken,
 	}, nil
 }
 
 // GetRegistryObjectFromHash returns a repository object (image or image index) from its hash.
-func (c *containerRegistryClientImpl) GetRegistryObjectFromHash(repository, hash string) (v1.Image, v1.ImageIndex, error) {
+func (c *containerRegistryClientImpl) GetRegistryObjectFromHash(ctx context.Context, repository, hash string) (v1.Image, v1.ImageIndex, error) {
 	// Build the digest from the repository and hash.
 	objectFullName := fmt.Sprintf("%s@%s", repository, hash)
 	digest, err := name.NewDigest(objectFullName, name.StrictValidation)
@@ -42,9 +133,9 @@ func (c *containerRegistryClientImpl) GetRegistryObjectFromHash(repository, hash
 	}
 
 	// Retrieve the descriptor for the digest.
-	descriptor, err := remote.Get(digest, remote.WithAuth(c.auth))
+	descriptor, err := remote.Get(digest, c.getOptions(ctx)...)
 	if err != nil {
-		return nil, nil, fmt.Errorf("unable to retrieve descriptor from digest '%s': %w", digest, err)
+		return nil, nil, fmt.Errorf("unable to retrieve descriptor from digest '%s': %w", digest, withRegistryScopeHint(err, "read:packages"))
 	}
 
 	// Analyse the manifest.
@@ -53,7 +144,7 @@ func (c *containerRegistryClientImpl) GetRegistryObjectFromHash(repository, hash
 		// Image manifest.
 		image, err := descriptor.Image()
 		if err != nil {
-			return nil, nil, fmt.Errorf("unable to retrieve image from descriptor '%v': %w", descriptor, err)
+			return nil, nil, fmt.Errorf("unable to retrieve image from digest '%s': %w", digest, err)
 		}
 		return image, nil, nil
 
@@ -61,18 +152,18 @@ func (c *containerRegistryClientImpl) GetRegistryObjectFromHash(repository, hash
 		// Image index manifest.
 		index, err := descriptor.ImageIndex()
 		if err != nil {
-			return nil, nil, fmt.Errorf("unable to retrieve image index from descriptor '%v': %w", descriptor, err)
+			return nil, nil, fmt.Errorf("unable to retrieve image index from digest '%s': %w", digest, err)
 		}
 		return nil, index, nil
 
 	default:
 		// Unmanaged manifest type.
-		return nil, nil, fmt.Errorf("unmanaged media type for descriptor '%v': %s", descriptor, descriptor.Descriptor.MediaType)
+		return nil, nil, fmt.Errorf("unmanaged media type for digest '%s': %s", digest, descriptor.Descriptor.MediaType)
 	}
 }
 
 // DeleteRegistryObject deletes a repository object from its hash.
-func (c *containerRegistryClientImpl) DeleteRegistryObject(repository, hash string) error {
+func (c *containerRegistryClientImpl) DeleteRegistryObject(ctx context.Context, repository, hash string) error {
 	// Build the digest from the repository and hash.
 	objectFullName := fmt.Sprintf("%s@%s", repository, hash)
 	digest, err := name.NewDigest(objectFullName, name.StrictValidation)
@@ -81,10 +172,132 @@ func (c *containerRegistryClientImpl) DeleteRegistryObject(repository, hash stri
 	}
 
 	// Delete the object.
-	err = remote.Delete(digest, remote.WithAuth(c.auth))
+	err = remote.Delete(digest, c.getOptions(ctx)...)
 	if err != nil {
-		return fmt.Errorf("unable to delete object from digest '%s': %w", digest, err)
+		return fmt.Errorf("unable to delete object from digest '%s': %w", digest, withRegistryScopeHint(err, "read:packages, delete:packages"))
 	}
 
 	return nil
 }
+
+// ListTags returns all the tags of a repository.
+func (c *containerRegistryClientImpl) ListTags(ctx context.Context, repository string) ([]string, error) {
+	repo, err := name.NewRepository(repository, name.StrictValidation)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repository '%s': %w", repository, err)
+	}
+
+	tags, err := remote.List(repo, c.getOptions(ctx)...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the tags of repository '%s': %w", repository, withRegistryScopeHint(err, "read:packages"))
+	}
+
+	return tags, nil
+}
+
+// GetTagHash returns the hash of the object referenced by a tag.
+func (c *containerRegistryClientImpl) GetTagHash(ctx context.Context, repository, tag string) (string, error) {
+	ref, err := name.NewTag(fmt.Sprintf("%s:%s", repository, tag), name.StrictValidation)
+	if err != nil {
+		return "", fmt.Errorf("invalid tag '%s': %w", tag, err)
+	}
+
+	descriptor, err := remote.Head(ref, c.getOptions(ctx)...)
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve tag '%s': %w", ref, withRegistryScopeHint(err, "read:packages"))
+	}
+
+	return descriptor.Digest.String(), nil
+}
+
+// GetReferrers returns the hashes of the objects referring to an object (e.g. signatures and attestations), using the
+// referrers API or the tag schema fallback if the registry does not support it.
+func (c *containerRegistryClientImpl) GetReferrers(ctx context.Context, repository, hash string) ([]string, error) {
+	digest, err := name.NewDigest(fmt.Sprintf("%s@%s", repository, hash), name.StrictValidation)
+	if err != nil {
+		return nil, fmt.Errorf("unable to build digest from hash '%s': %w", hash, err)
+	}
+
+	index, err := remote.Referrers(digest, c.getOptions(ctx)...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the referrers of digest '%s': %w", digest, withRegistryScopeHint(err, "read:packages"))
+	}
+
+	indexManifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the referrers index manifest of digest '%s': %w", digest, err)
+	}
+
+	var hashes []string
+	for _, manifest := range indexManifest.Manifests {
+		hashes = append(hashes, manifest.Digest.String())
+	}
+	return hashes, nil
+}
+
+// WriteIndex pushes an image index whose manifests are already in the repository, under each of the tags, or by digest
+// if it has none.
+func (c *containerRegistryClientImpl) WriteIndex(ctx context.Context, repository string, index v1.ImageIndex, tags []string) error {
+	digest, err := index.Digest()
+	if err != nil {
+		return fmt.Errorf("unable to compute the image index digest: %w", err)
+	}
+
+	if len(tags) == 0 {
+		ref, err := name.NewDigest(fmt.Sprintf("%s@%s", repository, digest), name.StrictValidation)
+		if err != nil {
+			return fmt.Errorf("unable to build digest from hash '%s': %w", digest, err)
+		}
+
+		err = remote.WriteIndex(ref, index, c.getOptions(ctx)...)
+		if err != nil {
+			return fmt.Errorf("unable to push image index '%s': %w", ref, withRegistryScopeHint(err, "write:packages"))
+		}
+		return nil
+	}
+
+	for i, tag := range tags {
+		ref, err := name.NewTag(fmt.Sprintf("%s:%s", repository, tag), name.StrictValidation)
+		if err != nil {
+			return fmt.Errorf("invalid tag '%s': %w", tag, err)
+		}
+
+		// Push the index once, then only tag it.
+		if i == 0 {
+			err = remote.WriteIndex(ref, index, c.getOptions(ctx)...)
+		} else {
+			err = remote.Tag(ref, index, c.getOptions(ctx)...)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to push image index '%s' to tag '%s': %w", digest, ref, withRegistryScopeHint(err, "write:packages"))
+		}
+	}
+
+	return nil
+}
+
+// getOptions returns the options of the remote calls.
+func (c *containerRegistryClientImpl) getOptions(ctx context.Context) []remote.Option {
+	return []remote.Option{
+		c.authOption,
+		remote.WithContext(ctx),
+		remote.WithTransport(c.transport),
+		// The rate limits and transient errors are already retried by the transport, disable the retries of the
+		// library so that they are not multiplied.
+		remote.WithRetryStatusCodes(),
+		remote.WithRetryBackoff(remote.Backoff{Steps: 1}),
+		remote.WithRetryPredicate(func(error) bool { return false }),
+	}
+}
+
+// withRegistryScopeHint adds a hint about the required token scopes to an error caused by a lack of permission.
+func withRegistryScopeHint(err error, requiredScopes string) error {
+	var transportErr *transport.Error
+	if errors.As(err, &transportErr) {
+		switch transportErr.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return fmt.Errorf("%w (the registry password may lack the required scope(s) '%s')", err, requiredScopes)
+		}
+	}
+	return err
+}
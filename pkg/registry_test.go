@@ -0,0 +1,202 @@
+package pkg
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//
+// Test suite definition.
+//
+
+type RegistryTestSuite struct {
+	suite.Suite
+}
+
+func TestRegistryTestSuite(t *testing.T) {
+	suite.Run(t, new(RegistryTestSuite))
+}
+
+//
+// Tests.
+//
+
+func (s *RegistryTestSuite) TestGetKeychainPassword() {
+	// Create a Docker configuration with the credentials of a single registry.
+	dir := s.T().TempDir()
+	auth := base64.StdEncoding.EncodeToString([]byte("user:secret"))
+	config := `{"auths": {"ghcr.io": {"auth": "` + auth + `"}}}`
+	s.Require().NoError(os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600))
+	s.T().Setenv("DOCKER_CONFIG", dir)
+
+	r := s.Require()
+
+	password, err := GetKeychainPassword("ghcr.io")
+	r.NoError(err)
+	r.Equal("secret", password)
+
+	password, err = GetKeychainPassword("registry.example.com")
+	r.NoError(err)
+	r.Empty(password)
+}
+
+func (s *RegistryTestSuite) TestGetAndDeleteRegistryObject() {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	repository, hash := s.pushRandomImage(server)
+
+	client, err := NewContainerRegistryClient("user", "password", DefaultRegistryRequestTimeout)
+	r := s.Require()
+	r.NoError(err)
+
+	image, index, err := client.GetRegistryObjectFromHash(context.Background(), repository, hash)
+	r.NoError(err)
+	r.NotNil(image)
+	r.Nil(index)
+
+	r.NoError(client.DeleteRegistryObject(context.Background(), repository, hash))
+	_, _, err = client.GetRegistryObjectFromHash(context.Background(), repository, hash)
+	r.Error(err)
+}
+
+func (s *RegistryTestSuite) TestRegistryRequestTimeout() {
+	// Hang on the first manifest request.
+	var manifestRequests int32
+	handler := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/manifests/") && r.Method == http.MethodGet && atomic.AddInt32(&manifestRequests, 1) == 1 {
+			<-r.Context().Done()
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	repository, hash := s.pushRandomImage(server)
+
+	client, err := NewContainerRegistryClient("user", "password", 200*time.Millisecond)
+	r := s.Require()
+	r.NoError(err)
+
+	// The request is retried after the timeout.
+	image, _, err := client.GetRegistryObjectFromHash(context.Background(), repository, hash)
+	r.NoError(err)
+	r.NotNil(image)
+	r.Equal(int32(2), atomic.LoadInt32(&manifestRequests))
+}
+
+func (s *RegistryTestSuite) TestRegistryRequestCancelled() {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	repository, hash := s.pushRandomImage(server)
+
+	client, err := NewContainerRegistryClient("user", "password", DefaultRegistryRequestTimeout)
+	r := s.Require()
+	r.NoError(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = client.GetRegistryObjectFromHash(ctx, repository, hash)
+	r.ErrorIs(err, context.Canceled)
+	r.Error(client.DeleteRegistryObject(ctx, repository, hash))
+}
+
+func (s *RegistryTestSuite) TestListAndResolveTags() {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0)), registry.WithReferrersSupport(true)))
+	defer server.Close()
+	repository, hash := s.pushRandomImage(server)
+
+	client, err := NewContainerRegistryClient("user", "password", DefaultRegistryRequestTimeout)
+	r := s.Require()
+	r.NoError(err)
+
+	tags, err := client.ListTags(context.Background(), repository)
+	r.NoError(err)
+	r.Equal([]string{"latest"}, tags)
+
+	tagHash, err := client.GetTagHash(context.Background(), repository, "latest")
+	r.NoError(err)
+	r.Equal(hash, tagHash)
+
+	_, err = client.GetTagHash(context.Background(), repository, "unknown")
+	r.Error(err)
+}
+
+func (s *RegistryTestSuite) TestGetReferrers() {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0)), registry.WithReferrersSupport(true)))
+	defer server.Close()
+	repository, hash := s.pushRandomImage(server)
+
+	client, err := NewContainerRegistryClient("user", "password", DefaultRegistryRequestTimeout)
+	r := s.Require()
+	r.NoError(err)
+
+	// No referrer yet.
+	referrers, err := client.GetReferrers(context.Background(), repository, hash)
+	r.NoError(err)
+	r.Empty(referrers)
+
+	// Push a referrer of the image.
+	referrerHash := pushReferrer(r, repository, hash)
+	referrers, err = client.GetReferrers(context.Background(), repository, hash)
+	r.NoError(err)
+	r.Equal([]string{referrerHash}, referrers)
+}
+
+// pushRandomImage pushes a random image to a test registry, and returns its repository and hash.
+func (s *RegistryTestSuite) pushRandomImage(server *httptest.Server) (string, string) {
+	r := s.Require()
+
+	repository := strings.TrimPrefix(server.URL, "http://") + "/user/package"
+	ref, err := name.NewTag(repository + ":latest")
+	r.NoError(err)
+
+	image, err := random.Image(256, 1)
+	r.NoError(err)
+	r.NoError(remote.Write(ref, image))
+
+	digest, err := image.Digest()
+	r.NoError(err)
+
+	return repository, digest.String()
+}
+
+// pushReferrer pushes an untagged random image referring to a subject, and returns its hash.
+func pushReferrer(r *require.Assertions, repository, subjectHash string) string {
+	subjectRef, err := name.NewDigest(repository + "@" + subjectHash)
+	r.NoError(err)
+	subject, err := remote.Head(subjectRef)
+	r.NoError(err)
+
+	image, err := random.Image(64, 1)
+	r.NoError(err)
+	image = mutate.MediaType(image, types.OCIManifestSchema1)
+	image = mutate.ConfigMediaType(image, types.OCIConfigJSON)
+	image = mutate.Subject(image, *subject).(v1.Image)
+
+	digest, err := image.Digest()
+	r.NoError(err)
+	ref, err := name.NewDigest(repository + "@" + digest.String())
+	r.NoError(err)
+	r.NoError(remote.Write(ref, image))
+
+	return digest.String()
+}
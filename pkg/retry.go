@@ -0,0 +1,278 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// The maximum number of retries of a request.
+	defaultMaxRetries = 5
+
+	// The bounds of the exponential backoff between retries on transient errors.
+	defaultMinBackoff = time.Second
+	defaultMaxBackoff = 30 * time.Second
+
+	// The wait before retrying after a secondary rate limit without any indication of the delay, as recommended by the
+	// GitHub documentation.
+	defaultSecondaryRateLimitWait = time.Minute
+
+	// The maximum wait before retrying after a rate limit, the request failing if the limit is reset later.
+	defaultMaxRateLimitWait = 15 * time.Minute
+)
+
+// retryTransport is an HTTP transport retrying the requests failing because of a rate limit or of a transient error.
+// On a primary rate limit it waits until the limit is reset, on a secondary one it honors the `Retry-After` header, and
+// on a server error or a network error it backs off exponentially with jitter. The request is not retried if the wait
+// is longer than the maximum one, or if it would exceed the deadline of the request.
+type retryTransport struct {
+	base       http.RoundTripper
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	maxWait    time.Duration
+
+	// Whether the requests target the GitHub API, whose 403 responses without any delay may still be secondary rate
+	// limits. For the other hosts, e.g. the registries, they are permission errors.
+	githubAPI bool
+
+	// The timeout of each attempt, no timeout if 0.
+	attemptTimeout time.Duration
+
+	// The function used to wait between the retries, replaceable for the tests.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+func newRetryTransport(base http.RoundTripper) *retryTransport {
+	return &retryTransport{
+		base:       base,
+		maxRetries: defaultMaxRetries,
+		minBackoff: defaultMinBackoff,
+		maxBackoff: defaultMaxBackoff,
+		maxWait:    defaultMaxRateLimitWait,
+		sleep:      sleepContext,
+	}
+}
+
+// newGithubRetryTransport returns a retry transport for the requests to the GitHub API.
+func newGithubRetryTransport(base http.RoundTripper) *retryTransport {
+	transport := newRetryTransport(base)
+	transport.githubAPI = true
+	return transport
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		// Rewind the request body, if any, before retrying.
+		var body io.ReadCloser
+		if attempt > 0 && req.Body != nil {
+			if req.GetBody == nil {
+				return nil, fmt.Errorf("unable to retry request '%s %s', its body cannot be rewound", req.Method, req.URL)
+			}
+
+			var err error
+			body, err = req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("unable to rewind the body of request '%s %s': %w", req.Method, req.URL, err)
+			}
+		}
+
+		// Send a copy of the request, the one of the caller is not modified, and bound the duration of the attempt if
+		// needed.
+		ctx := req.Context()
+		cancel := context.CancelFunc(func() {})
+		if t.attemptTimeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, t.attemptTimeout)
+		}
+		attemptReq := req.Clone(ctx)
+		if body != nil {
+			attemptReq.Body = body
+		}
+
+		resp, err := t.base.RoundTrip(attemptReq)
+
+		// Check if the request must be retried.
+		wait, reason, rateLimit := t.getRetryWait(resp, err, attempt)
+		retry := wait >= 0 && attempt < t.maxRetries && req.Context().Err() == nil
+		if retry && !t.canWait(req.Context(), wait) {
+			log.Warn().
+				Str("method", req.Method).
+				Str("url", req.URL.String()).
+				Str("reason", reason).
+				Dur("wait", wait).
+				Msg("wait before retrying the request too long, giving up")
+			retry = false
+		}
+		if !retry {
+			if resp == nil {
+				cancel()
+				return resp, err
+			}
+
+			// The attempt context must live until the response body is read.
+			resp.Body = &cancelOnCloseBody{ReadCloser: resp.Body, cancel: cancel}
+			return resp, err
+		}
+
+		// The waits caused by a rate limit may be long, they are reported.
+		event := log.Debug()
+		if rateLimit {
+			event = log.Warn()
+		}
+		event.
+			Str("method", req.Method).
+			Str("url", req.URL.String()).
+			Str("reason", reason).
+			Dur("wait", wait).
+			Int("attempt", attempt+1).
+			Msg("retrying request")
+
+		// Release the connection of the response before retrying.
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+		cancel()
+
+		if err := t.sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// canWait returns whether a wait before retrying a request is neither longer than the maximum one nor past the deadline
+// of the request.
+func (t *retryTransport) canWait(ctx context.Context, wait time.Duration) bool {
+	if wait > t.maxWait {
+		return false
+	}
+	deadline, found := ctx.Deadline()
+	return !found || time.Until(deadline) > wait
+}
+
+// getRetryWait returns the duration to wait before retrying a request, the reason of the retry and whether it is caused
+// by a rate limit, or a negative duration if the request must not be retried.
+func (t *retryTransport) getRetryWait(resp *http.Response, err error, attempt int) (time.Duration, string, bool) {
+	if err != nil {
+		// Network error, unless it is a TLS one that will not resolve itself, e.g. when an HTTP-only registry is probed.
+		if isTLSError(err) {
+			return -1, "", false
+		}
+		return t.getBackoff(attempt), err.Error(), false
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests:
+		// Secondary rate limit, with an indication of the delay.
+		if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+			if seconds, err := strconv.Atoi(retryAfter); err == nil {
+				return time.Duration(seconds) * time.Second, "secondary rate limit", true
+			}
+		}
+
+		// Primary rate limit, wait until it is reset.
+		if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+			if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+				wait := time.Until(time.Unix(reset, 0)) + time.Second
+				if wait < 0 {
+					wait = 0
+				}
+				return wait, "primary rate limit", true
+			}
+		}
+
+		// Secondary rate limit of the GitHub API, without any indication of the delay.
+		if t.githubAPI && (resp.StatusCode == http.StatusTooManyRequests || isSecondaryRateLimitResponse(resp)) {
+			return defaultSecondaryRateLimitWait, "secondary rate limit", true
+		}
+
+		// Rate limit of another host, without any indication of the delay.
+		if resp.StatusCode == http.StatusTooManyRequests {
+			return t.getBackoff(attempt), resp.Status, true
+		}
+
+		// Permission error.
+		return -1, "", false
+
+	case resp.StatusCode == http.StatusInternalServerError,
+		resp.StatusCode == http.StatusBadGateway,
+		resp.StatusCode == http.StatusServiceUnavailable,
+		resp.StatusCode == http.StatusGatewayTimeout:
+		// Transient server error.
+		return t.getBackoff(attempt), resp.Status, false
+
+	default:
+		return -1, "", false
+	}
+}
+
+// isTLSError returns whether an error is caused by the TLS handshake or by an invalid certificate.
+func isTLSError(err error) bool {
+	var recordHeaderErr tls.RecordHeaderError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certificateInvalidErr x509.CertificateInvalidError
+
+	return errors.As(err, &recordHeaderErr) ||
+		errors.As(err, &unknownAuthorityErr) ||
+		errors.As(err, &hostnameErr) ||
+		errors.As(err, &certificateInvalidErr)
+}
+
+// isSecondaryRateLimitResponse returns whether a response body reports a secondary rate limit. The body is restored
+// so that it can still be read by the caller.
+func isSecondaryRateLimitResponse(resp *http.Response) bool {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+
+	return strings.Contains(strings.ToLower(string(body)), "secondary rate limit")
+}
+
+// getBackoff returns the exponential backoff for an attempt, with a random jitter of up to half of it.
+func (t *retryTransport) getBackoff(attempt int) time.Duration {
+	backoff := t.minBackoff << attempt
+	if backoff <= 0 || backoff > t.maxBackoff {
+		backoff = t.maxBackoff
+	}
+
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// cancelOnCloseBody is a response body cancelling the context of its request when closed.
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnCloseBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// sleepContext waits for a duration, or until the context is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,379 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/suite"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+//
+// Test suite definition.
+//
+
+type RetryTestSuite struct {
+	suite.Suite
+}
+
+func TestRetryTestSuite(t *testing.T) {
+	suite.Run(t, new(RetryTestSuite))
+}
+
+//
+// Helpers.
+//
+
+// newTestRetryTransport returns a retry transport of the GitHub API recording the waits instead of sleeping.
+func newTestRetryTransport(waits *[]time.Duration) *retryTransport {
+	transport := newGithubRetryTransport(http.DefaultTransport)
+	transport.sleep = func(_ context.Context, d time.Duration) error {
+		*waits = append(*waits, d)
+		return nil
+	}
+	return transport
+}
+
+// newTestServer returns a server replying with the given handlers in sequence, the last one being repeated.
+func newTestServer(handlers ...http.HandlerFunc) (*httptest.Server, *int) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler := handlers[len(handlers)-1]
+		if calls < len(handlers) {
+			handler = handlers[calls]
+		}
+		calls++
+		handler(w, r)
+	}))
+	return server, &calls
+}
+
+func replyStatus(status int, headers map[string]string, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		for k, v := range headers {
+			w.Header().Set(k, v)
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}
+}
+
+//
+// Tests.
+//
+
+func (s *RetryTestSuite) TestServerErrorRetried() {
+	server, calls := newTestServer(
+		replyStatus(http.StatusBadGateway, nil, ""),
+		replyStatus(http.StatusServiceUnavailable, nil, ""),
+		replyStatus(http.StatusOK, nil, "ok"),
+	)
+	defer server.Close()
+
+	var waits []time.Duration
+	client := &http.Client{Transport: newTestRetryTransport(&waits)}
+
+	r := s.Require()
+	resp, err := client.Get(server.URL)
+	r.NoError(err)
+	defer resp.Body.Close()
+	r.Equal(http.StatusOK, resp.StatusCode)
+	r.Equal(3, *calls)
+
+	// Exponential backoff with jitter.
+	r.Len(waits, 2)
+	r.GreaterOrEqual(waits[0], defaultMinBackoff/2)
+	r.LessOrEqual(waits[0], defaultMinBackoff)
+	r.GreaterOrEqual(waits[1], defaultMinBackoff)
+	r.LessOrEqual(waits[1], 2*defaultMinBackoff)
+}
+
+func (s *RetryTestSuite) TestMaxRetries() {
+	server, calls := newTestServer(replyStatus(http.StatusInternalServerError, nil, ""))
+	defer server.Close()
+
+	var waits []time.Duration
+	client := &http.Client{Transport: newTestRetryTransport(&waits)}
+
+	r := s.Require()
+	resp, err := client.Get(server.URL)
+	r.NoError(err)
+	defer resp.Body.Close()
+	r.Equal(http.StatusInternalServerError, resp.StatusCode)
+	r.Equal(defaultMaxRetries+1, *calls)
+	r.Len(waits, defaultMaxRetries)
+	for _, wait := range waits {
+		r.LessOrEqual(wait, defaultMaxBackoff)
+	}
+}
+
+func (s *RetryTestSuite) TestSecondaryRateLimitRetryAfter() {
+	server, calls := newTestServer(
+		replyStatus(http.StatusForbidden, map[string]string{"Retry-After": "42"}, ""),
+		replyStatus(http.StatusOK, nil, "ok"),
+	)
+	defer server.Close()
+
+	var waits []time.Duration
+	client := &http.Client{Transport: newTestRetryTransport(&waits)}
+
+	r := s.Require()
+	resp, err := client.Get(server.URL)
+	r.NoError(err)
+	defer resp.Body.Close()
+	r.Equal(http.StatusOK, resp.StatusCode)
+	r.Equal(2, *calls)
+	r.Equal([]time.Duration{42 * time.Second}, waits)
+}
+
+func (s *RetryTestSuite) TestSecondaryRateLimitWithoutDelay() {
+	server, calls := newTestServer(
+		replyStatus(http.StatusForbidden, nil, `{"message": "You have exceeded a secondary rate limit."}`),
+		replyStatus(http.StatusOK, nil, "ok"),
+	)
+	defer server.Close()
+
+	var waits []time.Duration
+	client := &http.Client{Transport: newTestRetryTransport(&waits)}
+
+	r := s.Require()
+	resp, err := client.Get(server.URL)
+	r.NoError(err)
+	defer resp.Body.Close()
+	r.Equal(http.StatusOK, resp.StatusCode)
+	r.Equal(2, *calls)
+	r.Equal([]time.Duration{defaultSecondaryRateLimitWait}, waits)
+}
+
+func (s *RetryTestSuite) TestPrimaryRateLimit() {
+	reset := time.Now().Add(10 * time.Minute).Unix()
+	server, calls := newTestServer(
+		replyStatus(http.StatusForbidden, map[string]string{
+			"X-RateLimit-Remaining": "0",
+			"X-RateLimit-Reset":     strconv.FormatInt(reset, 10),
+		}, ""),
+		replyStatus(http.StatusOK, nil, "ok"),
+	)
+	defer server.Close()
+
+	var waits []time.Duration
+	client := &http.Client{Transport: newTestRetryTransport(&waits)}
+
+	r := s.Require()
+	resp, err := client.Get(server.URL)
+	r.NoError(err)
+	defer resp.Body.Close()
+	r.Equal(http.StatusOK, resp.StatusCode)
+	r.Equal(2, *calls)
+
+	// Wait until the reset of the limit.
+	r.Len(waits, 1)
+	r.InDelta((10 * time.Minute).Seconds(), waits[0].Seconds(), 5)
+}
+
+func (s *RetryTestSuite) TestRateLimitWaitTooLong() {
+	server, calls := newTestServer(
+		replyStatus(http.StatusForbidden, map[string]string{"Retry-After": "3600"}, ""),
+		replyStatus(http.StatusOK, nil, "ok"),
+	)
+	defer server.Close()
+
+	var waits []time.Duration
+	client := &http.Client{Transport: newTestRetryTransport(&waits)}
+
+	// The wait is longer than the maximum one, the rate limit response is returned.
+	r := s.Require()
+	resp, err := client.Get(server.URL)
+	r.NoError(err)
+	defer resp.Body.Close()
+	r.Equal(http.StatusForbidden, resp.StatusCode)
+	r.Equal(1, *calls)
+	r.Empty(waits)
+}
+
+func (s *RetryTestSuite) TestRateLimitWaitPastDeadline() {
+	server, calls := newTestServer(
+		replyStatus(http.StatusForbidden, map[string]string{"Retry-After": "42"}, ""),
+		replyStatus(http.StatusOK, nil, "ok"),
+	)
+	defer server.Close()
+
+	var waits []time.Duration
+	client := &http.Client{Transport: newTestRetryTransport(&waits)}
+
+	// The wait would exceed the deadline, the rate limit response is returned.
+	r := s.Require()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	r.NoError(err)
+	resp, err := client.Do(req)
+	r.NoError(err)
+	defer resp.Body.Close()
+	r.Equal(http.StatusForbidden, resp.StatusCode)
+	r.Equal(1, *calls)
+	r.Empty(waits)
+}
+
+func (s *RetryTestSuite) TestPermissionErrorNotRetried() {
+	server, calls := newTestServer(replyStatus(http.StatusForbidden, nil, `{"message": "Resource not accessible"}`))
+	defer server.Close()
+
+	var waits []time.Duration
+	client := &http.Client{Transport: newTestRetryTransport(&waits)}
+
+	r := s.Require()
+	resp, err := client.Get(server.URL)
+	r.NoError(err)
+	defer resp.Body.Close()
+	r.Equal(http.StatusForbidden, resp.StatusCode)
+	r.Equal(1, *calls)
+	r.Empty(waits)
+
+	// The body can still be read.
+	body, err := io.ReadAll(resp.Body)
+	r.NoError(err)
+	r.Contains(string(body), "Resource not accessible")
+}
+
+func (s *RetryTestSuite) TestRegistryRateLimits() {
+	server, calls := newTestServer(
+		replyStatus(http.StatusTooManyRequests, nil, ""),
+		replyStatus(http.StatusForbidden, nil, `{"errors": [{"code": "DENIED", "message": "secondary rate limit"}]}`),
+	)
+	defer server.Close()
+
+	var waits []time.Duration
+	transport := newTestRetryTransport(&waits)
+	transport.githubAPI = false
+	transport.minBackoff = time.Millisecond
+	transport.maxBackoff = time.Millisecond
+	client := &http.Client{Transport: transport}
+
+	// A registry rate limit is retried with a backoff, a registry 403 is a permission error whatever its body.
+	r := s.Require()
+	resp, err := client.Get(server.URL)
+	r.NoError(err)
+	defer resp.Body.Close()
+	r.Equal(http.StatusForbidden, resp.StatusCode)
+	r.Equal(2, *calls)
+	r.Len(waits, 1)
+	r.Less(waits[0], defaultSecondaryRateLimitWait)
+}
+
+func (s *RetryTestSuite) TestRequestBodyRewound() {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	var waits []time.Duration
+	client := &http.Client{Transport: newTestRetryTransport(&waits)}
+
+	r := s.Require()
+	resp, err := client.Post(server.URL, "text/plain", strings.NewReader("payload"))
+	r.NoError(err)
+	defer resp.Body.Close()
+	r.Equal(http.StatusOK, resp.StatusCode)
+	r.Equal([]string{"payload", "payload"}, bodies)
+}
+
+func (s *RetryTestSuite) TestRequestNotModified() {
+	server, calls := newTestServer(
+		replyStatus(http.StatusBadGateway, nil, ""),
+		replyStatus(http.StatusOK, nil, "ok"),
+	)
+	defer server.Close()
+
+	var waits []time.Duration
+	transport := newTestRetryTransport(&waits)
+
+	// The rewound body is distinguishable from the original one.
+	r := s.Require()
+	req, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader("payload"))
+	r.NoError(err)
+	originalBody := req.Body
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewBufferString("payload")), nil
+	}
+
+	resp, err := transport.RoundTrip(req)
+	r.NoError(err)
+	defer resp.Body.Close()
+	r.Equal(http.StatusOK, resp.StatusCode)
+	r.Equal(2, *calls)
+	r.Equal(originalBody, req.Body)
+}
+
+func (s *RetryTestSuite) TestAttemptTimeout() {
+	server, calls := newTestServer(
+		func(w http.ResponseWriter, r *http.Request) {
+			// Hang until the attempt times out.
+			<-r.Context().Done()
+		},
+		replyStatus(http.StatusOK, nil, "ok"),
+	)
+	defer server.Close()
+
+	var waits []time.Duration
+	transport := newTestRetryTransport(&waits)
+	transport.attemptTimeout = 100 * time.Millisecond
+	client := &http.Client{Transport: transport}
+
+	r := s.Require()
+	resp, err := client.Get(server.URL)
+	r.NoError(err)
+	defer resp.Body.Close()
+	r.Equal(http.StatusOK, resp.StatusCode)
+	r.Equal(2, *calls)
+	r.Len(waits, 1)
+
+	// The body can still be read once the request returned.
+	body, err := io.ReadAll(resp.Body)
+	r.NoError(err)
+	r.Equal("ok", string(body))
+}
+
+func (s *RetryTestSuite) TestCancelledRequestNotRetried() {
+	server, calls := newTestServer(replyStatus(http.StatusBadGateway, nil, ""))
+	defer server.Close()
+
+	var waits []time.Duration
+	client := &http.Client{Transport: newTestRetryTransport(&waits)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	s.Require().NoError(err)
+
+	_, err = client.Do(req)
+	s.Require().ErrorIs(err, context.Canceled)
+	s.Require().Equal(0, *calls)
+	s.Require().Empty(waits)
+}
+
+func (s *RetryTestSuite) TestTLSErrorNotRetried() {
+	server, calls := newTestServer(replyStatus(http.StatusOK, nil, "ok"))
+	defer server.Close()
+
+	var waits []time.Duration
+	client := &http.Client{Transport: newTestRetryTransport(&waits)}
+
+	// Probe the HTTP server with HTTPS.
+	_, err := client.Get(strings.Replace(server.URL, "http://", "https://", 1))
+	s.Require().Error(err)
+	s.Require().Equal(0, *calls)
+	s.Require().Empty(waits)
+}